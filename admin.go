@@ -0,0 +1,221 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// requireAdmin rejects requests that do not carry the admin token as a Bearer token
+func (p *ProxyServer) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if p.adminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(p.adminToken)) != 1 {
+			p.logger.Warn("🔒 Rejected admin request to %s from %s", r.URL.Path, r.RemoteAddr)
+			p.writeError(w, r, newProxyError(http.StatusUnauthorized, CodeUnauthorized, "Invalid admin token"))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// startAdmin serves the admin API on its own listener so it can be kept off
+// the public network. It only runs when ADMIN_TOKEN is set.
+func (p *ProxyServer) startAdmin() {
+	addr := os.Getenv("ADMIN_ADDR")
+	if addr == "" {
+		addr = ":8081"
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/keys", p.requireAdmin(p.AdminKeysHandler))
+	mux.HandleFunc("/admin/keys/", p.requireAdmin(p.AdminKeysHandler))
+	mux.HandleFunc("/admin/counters/reset", p.requireAdmin(p.AdminResetHandler))
+	mux.HandleFunc("/admin/drain", p.requireAdmin(p.DrainHandler))
+	mux.HandleFunc("/admin/drain/", p.requireAdmin(p.DrainHandler))
+	mux.HandleFunc("/admin/dashboard", p.DashboardHandler)
+	mux.HandleFunc("/admin/dashboard/events", p.requireAdmin(p.DashboardEventsHandler))
+	mux.HandleFunc("/metrics", p.requireAdmin(p.MetricsHandler))
+
+	p.logger.Info("🛠️  Starting admin API on %s", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		p.logger.Error("Failed to start admin API: %v", err)
+		os.Exit(1)
+	}
+}
+
+// keyStatus describes a tracked API key for the admin API. Keys are only
+// ever shown as their SHA-256 hash.
+type keyStatus struct {
+	Hash           string              `json:"hash"`
+	LastFetch      time.Time           `json:"last_fetch"`
+	LastAccess     time.Time           `json:"last_access"`
+	RefreshRunning bool                `json:"refresh_running"`
+	Revalidating   bool                `json:"revalidating"`
+	Restored       bool                `json:"restored"`
+	LastError      string              `json:"last_error,omitempty"`
+	LastErrorAt    *time.Time          `json:"last_error_at,omitempty"`
+	WorkloadCount  int                 `json:"workload_count"`
+	Workloads      []WorkloadView      `json:"workloads,omitempty"`
+	Tags           map[string][]string `json:"tags"`
+	InFlight       map[string]int      `json:"in_flight"`
+}
+
+// keyStatusFor snapshots a key's cache and in-flight state
+func (p *ProxyServer) keyStatusFor(apiKey string, detailed bool) (keyStatus, bool) {
+	p.cacheLock.RLock()
+	cache, exists := p.workloadCache[apiKey]
+	if !exists {
+		p.cacheLock.RUnlock()
+		return keyStatus{}, false
+	}
+	status := keyStatus{
+		Hash:           hashKey(apiKey),
+		LastFetch:      cache.LastFetch,
+		LastAccess:     cache.LastAccess,
+		RefreshRunning: cache.StopRefresh != nil,
+		Revalidating:   cache.Revalidating,
+		Restored:       cache.Restored,
+		LastError:      cache.LastError,
+		WorkloadCount:  len(cache.Workloads),
+		Tags:           make(map[string][]string),
+		InFlight:       make(map[string]int),
+	}
+	if !cache.LastErrorAt.IsZero() {
+		lastErrorAt := cache.LastErrorAt
+		status.LastErrorAt = &lastErrorAt
+	}
+	workloads := cache.Workloads
+	for tag, nodes := range p.tagMappings[apiKey] {
+		status.Tags[tag] = append([]string(nil), nodes...)
+	}
+	p.cacheLock.RUnlock()
+
+	if detailed {
+		status.Workloads = p.workloadViews(workloads)
+	}
+
+	p.requestLock.RLock()
+	for node, count := range p.inFlightRequests[apiKey] {
+		status.InFlight[node] = count
+	}
+	p.requestLock.RUnlock()
+
+	return status, true
+}
+
+// trackedKeys returns every API key with a cache entry
+func (p *ProxyServer) trackedKeys() []string {
+	p.cacheLock.RLock()
+	defer p.cacheLock.RUnlock()
+
+	keys := make([]string, 0, len(p.workloadCache))
+	for apiKey := range p.workloadCache {
+		keys = append(keys, apiKey)
+	}
+	return keys
+}
+
+// AdminKeysHandler serves the tracked key endpoints:
+//
+//	GET    /admin/keys                list tracked keys
+//	GET    /admin/keys/{hash}         one key with its workloads
+//	POST   /admin/keys/{hash}/refresh fetch the key's workloads now
+//	DELETE /admin/keys/{hash}         evict the key and stop its refresh cycle
+func (p *ProxyServer) AdminKeysHandler(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/keys"), "/")
+	if rest == "" {
+		if r.Method != http.MethodGet {
+			p.writeError(w, r, newProxyError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Use GET to list keys"))
+			return
+		}
+		statuses := make([]keyStatus, 0)
+		for _, apiKey := range p.trackedKeys() {
+			if status, exists := p.keyStatusFor(apiKey, false); exists {
+				statuses = append(statuses, status)
+			}
+		}
+		sort.Slice(statuses, func(i, j int) bool { return statuses[i].Hash < statuses[j].Hash })
+		writeJSON(w, http.StatusOK, statuses)
+		return
+	}
+
+	hash, action, _ := strings.Cut(rest, "/")
+	apiKey, tracked := p.findKeyByHash(hash)
+	if !tracked {
+		p.writeError(w, r, newProxyError(http.StatusNotFound, CodeInvalidRequest, "API key %s is not tracked", hash))
+		return
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		status, _ := p.keyStatusFor(apiKey, true)
+		writeJSON(w, http.StatusOK, status)
+
+	case action == "" && r.Method == http.MethodDelete:
+		p.evictKey(apiKey)
+		p.logger.Info("🗑️  Evicted API key %s... via admin API", maskKey(apiKey))
+		w.WriteHeader(http.StatusNoContent)
+
+	case action == "refresh" && r.Method == http.MethodPost:
+		p.logger.Info("🔄 Refreshing workloads for API key %s... via admin API", maskKey(apiKey))
+		if _, err := p.forceRefreshWorkloads(r.Context(), apiKey); err != nil {
+			p.writeError(w, r, err)
+			return
+		}
+		status, _ := p.keyStatusFor(apiKey, true)
+		writeJSON(w, http.StatusOK, status)
+
+	default:
+		p.writeError(w, r, newProxyError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Unsupported admin action"))
+	}
+}
+
+// AdminResetHandler zeroes in-flight counters, for all keys or for the key
+// whose hash is given in ?key=. Use it to recover from counts left behind by
+// requests that never completed; requests still running when the counters
+// are reset will be clamped at zero when they finish.
+func (p *ProxyServer) AdminResetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		p.writeError(w, r, newProxyError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Use POST to reset counters"))
+		return
+	}
+
+	hash := r.URL.Query().Get("key")
+	var only string
+	if hash != "" {
+		apiKey, tracked := p.findKeyByHash(hash)
+		if !tracked {
+			p.writeError(w, r, newProxyError(http.StatusNotFound, CodeInvalidRequest, "API key %s is not tracked", hash))
+			return
+		}
+		only = apiKey
+	}
+
+	p.requestLock.Lock()
+	reset := make(map[string][]string)
+	for apiKey, nodes := range p.inFlightRequests {
+		if only != "" && apiKey != only {
+			continue
+		}
+		for node := range nodes {
+			reset[apiKey] = append(reset[apiKey], node)
+			if err := p.state.PublishInFlight(apiKey, node, 0); err != nil {
+				p.logger.Debug("⚠️  Failed to publish in-flight count for node %s: %v", node, err)
+			}
+		}
+		delete(p.inFlightRequests, apiKey)
+	}
+	p.requestLock.Unlock()
+
+	cleared := 0
+	for _, nodes := range reset {
+		cleared += len(nodes)
+	}
+
+	p.logger.Info("🧮 Reset %d in-flight counters for %d API keys via admin API", cleared, len(reset))
+	writeJSON(w, http.StatusOK, map[string]int{"keys": len(reset), "counters": cleared})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequireAdmin(t *testing.T) {
+	tests := []struct {
+		name, configured, header string
+		allowed                  bool
+	}{
+		{"matching token", "admin-token", "Bearer admin-token", true},
+		{"wrong token", "admin-token", "Bearer other-token", false},
+		{"missing header", "admin-token", "", false},
+		{"admin API disabled", "", "Bearer ", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProxy(t, map[string]string{"ADMIN_TOKEN": tt.configured})
+			called := false
+			handler := p.requireAdmin(func(w http.ResponseWriter, r *http.Request) { called = true })
+
+			r := httptest.NewRequest(http.MethodGet, "/admin/keys", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			handler(w, r)
+			if called != tt.allowed {
+				t.Fatalf("handler called = %v, want %v", called, tt.allowed)
+			}
+			if !tt.allowed && (w.Code != http.StatusUnauthorized || errorCode(t, w) != CodeUnauthorized) {
+				t.Errorf("rejected request = %d, want 401 unauthorized", w.Code)
+			}
+		})
+	}
+}
+
+func TestAdminKeysNeverShowsKeys(t *testing.T) {
+	p := newTestProxy(t, nil)
+	seedWorkloads(p, testKey, testWorkload("node-a", "w-1", "llama"))
+
+	w := httptest.NewRecorder()
+	p.AdminKeysHandler(w, httptest.NewRequest(http.MethodGet, "/admin/keys", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), hashKey(testKey)) {
+		t.Fatalf("key list = %d %s, want the key's hash", w.Code, w.Body)
+	}
+	if strings.Contains(w.Body.String(), testKey) {
+		t.Error("key list contains the API key in the clear")
+	}
+
+	w = httptest.NewRecorder()
+	p.AdminKeysHandler(w, httptest.NewRequest(http.MethodDelete, "/admin/keys/"+hashKey(testKey), nil))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("evicting the key = %d, want 204", w.Code)
+	}
+	w = httptest.NewRecorder()
+	p.AdminKeysHandler(w, httptest.NewRequest(http.MethodGet, "/admin/keys/"+hashKey(testKey), nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("evicted key = %d, want 404", w.Code)
+	}
+}
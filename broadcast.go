@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BroadcastConfig bounds broadcast requests
+type BroadcastConfig struct {
+	Timeout          time.Duration
+	MaxBodyBytes     int64
+	MaxResponseBytes int64
+}
+
+func loadBroadcastConfig() BroadcastConfig {
+	return BroadcastConfig{
+		Timeout:          getEnvDuration("BROADCAST_TIMEOUT", 30*time.Second),
+		MaxBodyBytes:     int64(getEnvInt("BROADCAST_MAX_BODY_BYTES", 1<<20)),
+		MaxResponseBytes: int64(getEnvInt("BROADCAST_MAX_RESPONSE_BYTES", 1<<20)),
+	}
+}
+
+// broadcastResult is one node's answer to a broadcast request
+type broadcastResult struct {
+	Node      string      `json:"node"`
+	Workload  string      `json:"workload,omitempty"`
+	Status    int         `json:"status,omitempty"`
+	LatencyMs int64       `json:"latency_ms"`
+	Body      interface{} `json:"body,omitempty"` // JSON bodies are embedded, others are strings
+	Truncated bool        `json:"truncated,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// broadcastResponse aggregates the answers of every node in a tag
+type broadcastResponse struct {
+	Tag       string            `json:"tag"`
+	Nodes     int               `json:"nodes"`
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+	Results   []broadcastResult `json:"results"`
+}
+
+// handleBroadcast sends /broadcast/tags/{tag}/path to every running node with
+// the tag concurrently and returns the aggregated results
+func (p *ProxyServer) handleBroadcast(w http.ResponseWriter, r *http.Request, apiKey string) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/broadcast/"), "/", 3)
+	if len(parts) < 2 || parts[0] != "tags" || parts[1] == "" {
+		p.writeError(w, r, newProxyError(http.StatusBadRequest, CodeInvalidPath, "Invalid broadcast path. Use /broadcast/tags/{tag}"))
+		return
+	}
+	tag := parts[1]
+	r.URL.Path = "/"
+	if len(parts) > 2 {
+		r.URL.Path = "/" + parts[2]
+	}
+	getRequestInfo(r).Tag = tag
+
+	nodes, err := p.broadcastNodes(apiKey, tag)
+	if err != nil {
+		p.writeError(w, r, err)
+		return
+	}
+
+	limits := p.limits.forTag(tag)
+	if limits.MaxBodyBytes > 0 && r.ContentLength > limits.MaxBodyBytes {
+		p.writeError(w, r, errBodyTooLarge)
+		return
+	}
+	reader := io.Reader(r.Body)
+	if r.Body == nil {
+		reader = http.NoBody
+	}
+	maxBody := p.broadcast.MaxBodyBytes
+	if limits.MaxBodyBytes > 0 && limits.MaxBodyBytes < maxBody {
+		maxBody = limits.MaxBodyBytes
+	}
+	body, err := io.ReadAll(io.LimitReader(reader, maxBody+1))
+	if err != nil {
+		p.writeError(w, r, newProxyError(http.StatusBadRequest, CodeInvalidRequest, "failed to read request body").wrap(err))
+		return
+	}
+	if int64(len(body)) > maxBody {
+		p.writeError(w, r, errBodyTooLarge)
+		return
+	}
+	r.ContentLength = int64(len(body))
+
+	ctx, cancel := context.WithTimeout(r.Context(), p.broadcast.Timeout)
+	defer cancel()
+
+	p.logger.Debug("📢 Broadcasting %s %s to %d nodes with tag %s", r.Method, r.URL.Path, len(nodes), tag)
+	results := make([]broadcastResult, len(nodes))
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		wg.Add(1)
+		go func(i int, node string) {
+			defer wg.Done()
+			results[i] = p.broadcastTo(ctx, r, apiKey, node, body)
+		}(i, node)
+	}
+	wg.Wait()
+
+	response := broadcastResponse{Tag: tag, Nodes: len(nodes), Results: results}
+	for _, result := range results {
+		if result.Error == "" && result.Status < http.StatusInternalServerError {
+			response.Succeeded++
+		} else {
+			response.Failed++
+		}
+	}
+	p.logger.Debug("📢 Broadcast to tag %s: %d succeeded, %d failed", tag, response.Succeeded, response.Failed)
+	writeJSON(w, http.StatusOK, response)
+}
+
+// broadcastNodes returns every running node matching a tag or tag selector
+func (p *ProxyServer) broadcastNodes(apiKey, tag string) ([]string, error) {
+	p.cacheLock.RLock()
+	defer p.cacheLock.RUnlock()
+
+	if tag != "all" {
+		return p.nodesForSelector(apiKey, tag)
+	}
+	var nodes []string
+	for node := range p.workloadsByNode(apiKey) {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	if len(nodes) == 0 {
+		return nil, newProxyError(http.StatusServiceUnavailable, CodeNoHealthyNodes, "no active nodes found")
+	}
+	return nodes, nil
+}
+
+// broadcastTo sends one copy of a broadcast request and reads the node's response
+func (p *ProxyServer) broadcastTo(ctx context.Context, r *http.Request, apiKey, node string, body []byte) broadcastResult {
+	result := broadcastResult{Node: node, Workload: p.workloadID(apiKey, node)}
+
+	ctx, span := p.tracer.Start(ctx, "proxy.upstream", spanKindClient)
+	span.SetAttr("server.address", node)
+	span.SetAttr("http.request.method", r.Method)
+	defer span.End()
+
+	req, err := p.newUpstreamRequest(ctx, r, node, apiKey, bytes.NewReader(body))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	span.inject(req.Header)
+
+	release := p.trackInFlight(apiKey, node)
+	defer release()
+
+	start := time.Now()
+	resp, err := p.upstream.Do(req)
+	if err != nil {
+		span.RecordError(err)
+		result.LatencyMs = time.Since(start).Milliseconds()
+		result.Error = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+	span.SetAttr("http.response.status_code", resp.StatusCode)
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, p.broadcast.MaxResponseBytes+1))
+	result.LatencyMs = time.Since(start).Milliseconds()
+	result.Status = resp.StatusCode
+	if err != nil {
+		span.RecordError(err)
+		result.Error = err.Error()
+	}
+	if int64(len(data)) > p.broadcast.MaxResponseBytes {
+		data = data[:p.broadcast.MaxResponseBytes]
+		result.Truncated = true
+	}
+	if len(data) > 0 {
+		if !result.Truncated && json.Valid(data) {
+			result.Body = json.RawMessage(data)
+		} else {
+			result.Body = string(data)
+		}
+	}
+	return result
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func decodeBroadcast(t *testing.T, w *httptest.ResponseRecorder) (broadcastResponse, map[string]map[string]interface{}) {
+	t.Helper()
+	if w.Code != http.StatusOK {
+		t.Fatalf("broadcast = %d %s, want 200", w.Code, w.Body)
+	}
+	raw := w.Body.Bytes()
+	var response broadcastResponse
+	if err := json.Unmarshal(raw, &response); err != nil {
+		t.Fatal(err)
+	}
+	// Results are decoded again generically to see the embedded bodies
+	var generic struct{ Results []map[string]interface{} }
+	json.Unmarshal(raw, &generic)
+	byNode := make(map[string]map[string]interface{})
+	for _, result := range generic.Results {
+		byNode[result["node"].(string)] = result
+	}
+	return response, byNode
+}
+
+func TestBroadcastFansOutToTag(t *testing.T) {
+	var mu sync.Mutex
+	received := make(map[string]string)
+	record := func(name string, status int, reply string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			mu.Lock()
+			received[name] = r.Method + " " + r.URL.Path + " " + string(body)
+			mu.Unlock()
+			w.WriteHeader(status)
+			w.Write([]byte(reply))
+		}
+	}
+	healthy := newTestNode(t, record("healthy", http.StatusOK, `{"ok": true}`))
+	broken := newTestNode(t, record("broken", http.StatusInternalServerError, "boom"))
+	other := newTestNode(t, record("other", http.StatusOK, "{}"))
+	p := newTestProxy(t, nil)
+	seedWorkloads(p, testKey, testWorkload(healthy, "w-1", "llama"), testWorkload(broken, "w-2", "llama"),
+		testWorkload(other, "w-3", "bge"))
+
+	w := doRequest(p, httptest.NewRequest(http.MethodPost, "/broadcast/tags/llama/v1/cache/clear", strings.NewReader(`{"all": true}`)))
+	response, results := decodeBroadcast(t, w)
+	if response.Tag != "llama" || response.Nodes != 2 || response.Succeeded != 1 || response.Failed != 1 {
+		t.Errorf("broadcast summary = %+v, want 1 of 2 llama nodes succeeding", response)
+	}
+	if ok := results[healthy]["body"].(map[string]interface{})["ok"]; ok != true || results[healthy]["workload"] != "w-1" {
+		t.Errorf("healthy result = %v, want its JSON body embedded", results[healthy])
+	}
+	if results[broken]["status"] != float64(http.StatusInternalServerError) || results[broken]["body"] != "boom" {
+		t.Errorf("broken result = %v, want status 500 and the text body", results[broken])
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, name := range []string{"healthy", "broken"} {
+		if got := received[name]; got != `POST /v1/cache/clear {"all": true}` {
+			t.Errorf("%s node received %q", name, got)
+		}
+	}
+	if _, sent := received["other"]; sent {
+		t.Error("a node without the tag received the broadcast")
+	}
+	if p.nodeInFlight(healthy) != 0 || p.nodeInFlight(broken) != 0 {
+		t.Error("in-flight counts were not released after the broadcast")
+	}
+}
+
+func TestBroadcastReportsUnreachableNodes(t *testing.T) {
+	up := newTestNode(t, func(w http.ResponseWriter, r *http.Request) {})
+	p := newTestProxy(t, nil)
+	seedWorkloads(p, testKey, testWorkload(up, "w-1", "llama"), testWorkload("127.0.0.1:1", "w-2", "llama"))
+
+	response, results := decodeBroadcast(t, doRequest(p, httptest.NewRequest(http.MethodGet, "/broadcast/tags/all/health", nil)))
+	if response.Nodes != 2 || response.Succeeded != 1 || response.Failed != 1 {
+		t.Errorf("broadcast summary = %+v, want the unreachable node to fail", response)
+	}
+	if results["127.0.0.1:1"]["error"] == nil {
+		t.Errorf("unreachable node result = %v, want an error", results["127.0.0.1:1"])
+	}
+}
+
+func TestBroadcastLimits(t *testing.T) {
+	node := newTestNode(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"long": "response"}`))
+	})
+	p := newTestProxy(t, map[string]string{"BROADCAST_MAX_BODY_BYTES": "8", "BROADCAST_MAX_RESPONSE_BYTES": "10"})
+	seedWorkloads(p, testKey, testWorkload(node, "w-1", "llama"))
+
+	_, results := decodeBroadcast(t, doRequest(p, httptest.NewRequest(http.MethodGet, "/broadcast/tags/llama/", nil)))
+	if results[node]["truncated"] != true || results[node]["body"] != `{"long": "` {
+		t.Errorf("oversized response = %v, want it truncated to a string", results[node])
+	}
+
+	w := doRequest(p, httptest.NewRequest(http.MethodPost, "/broadcast/tags/llama/", strings.NewReader("0123456789")))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized body = %d, want 413", w.Code)
+	}
+	w = doRequest(p, httptest.NewRequest(http.MethodGet, "/broadcast/llama/", nil))
+	if w.Code != http.StatusBadRequest || errorCode(t, w) != CodeInvalidPath {
+		t.Errorf("broadcast without /tags/ = %d, want 400 invalid_path", w.Code)
+	}
+}
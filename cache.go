@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ResponseCachePolicy enables response caching for a tag
+type ResponseCachePolicy struct {
+	TTL     Duration `json:"ttl,omitempty"`
+	Methods []string `json:"methods,omitempty"`
+}
+
+// ResponseCacheConfig bounds the response cache and lists the cached tags;
+// tags without a policy are never cached
+type ResponseCacheConfig struct {
+	MaxEntries    int                            `json:"max_entries,omitempty"`
+	MaxBytes      int64                          `json:"max_bytes,omitempty"`
+	MaxEntryBytes int64                          `json:"max_entry_bytes,omitempty"`
+	BypassHeader  string                         `json:"bypass_header,omitempty"`
+	Tags          map[string]ResponseCachePolicy `json:"tags"`
+}
+
+// cachedResponse is a stored node response
+type cachedResponse struct {
+	key     string
+	status  int
+	header  http.Header
+	body    []byte
+	stored  time.Time
+	expires time.Time
+}
+
+// ResponseCache is an LRU cache of complete node responses, keyed by API key,
+// tag, method, path and a hash of the canonicalized request body
+type ResponseCache struct {
+	cfg     ResponseCacheConfig
+	mu      sync.Mutex
+	lru     *list.List // most recently used first
+	entries map[string]*list.Element
+	bytes   int64
+
+	hits      *Counter
+	misses    *Counter
+	bypasses  *Counter
+	evictions *Counter
+}
+
+// loadResponseCache reads RESPONSE_CACHE (or RESPONSE_CACHE_FILE)
+func loadResponseCache(logger *Logger, metrics *Metrics) (*ResponseCache, error) {
+	cfg := ResponseCacheConfig{}
+	found, err := loadJSONEnv("RESPONSE_CACHE", &cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.MaxEntries == 0 {
+		cfg.MaxEntries = 1000
+	}
+	if cfg.MaxBytes == 0 {
+		cfg.MaxBytes = 64 << 20
+	}
+	if cfg.MaxEntryBytes == 0 {
+		cfg.MaxEntryBytes = 1 << 20
+	}
+	if cfg.BypassHeader == "" {
+		cfg.BypassHeader = "X-C3-Cache-Bypass"
+	}
+	for tag, policy := range cfg.Tags {
+		if policy.TTL <= 0 {
+			policy.TTL = Duration(5 * time.Minute)
+		}
+		if len(policy.Methods) == 0 {
+			policy.Methods = []string{http.MethodGet, http.MethodPost}
+		}
+		for i, method := range policy.Methods {
+			policy.Methods[i] = strings.ToUpper(method)
+		}
+		cfg.Tags[tag] = policy
+	}
+
+	c := &ResponseCache{
+		cfg:     cfg,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+
+		hits:      metrics.NewCounter("c3_proxy_response_cache_hits_total", "Requests answered from the response cache"),
+		misses:    metrics.NewCounter("c3_proxy_response_cache_misses_total", "Cacheable requests sent to a node"),
+		bypasses:  metrics.NewCounter("c3_proxy_response_cache_bypasses_total", "Requests that skipped the response cache"),
+		evictions: metrics.NewCounter("c3_proxy_response_cache_evictions_total", "Responses evicted to stay within the cache limits"),
+	}
+	metrics.NewGaugeFunc("c3_proxy_response_cache_entries", "Responses in the response cache", func() float64 {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return float64(c.lru.Len())
+	})
+	metrics.NewGaugeFunc("c3_proxy_response_cache_bytes", "Body bytes in the response cache", func() float64 {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return float64(c.bytes)
+	})
+
+	if found {
+		logger.Info("🗄️  Response cache enabled for %d tags (%d entries, %d bytes max)",
+			len(cfg.Tags), cfg.MaxEntries, cfg.MaxBytes)
+	}
+	return c, nil
+}
+
+// policyFor returns the cache policy for a request, or nil if it is not cacheable
+func (c *ResponseCache) policyFor(r *http.Request) *ResponseCachePolicy {
+	tag := getRequestInfo(r).Tag
+	if c == nil || tag == "" {
+		return nil
+	}
+	policy, ok := c.cfg.Tags[tag]
+	if !ok || !slices.Contains(policy.Methods, r.Method) {
+		return nil
+	}
+	return &policy
+}
+
+func (c *ResponseCache) get(key string) *cachedResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, exists := c.entries[key]
+	if !exists {
+		return nil
+	}
+	entry := element.Value.(*cachedResponse)
+	if time.Now().After(entry.expires) {
+		c.remove(element)
+		return nil
+	}
+	c.lru.MoveToFront(element)
+	return entry
+}
+
+func (c *ResponseCache) put(entry *cachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, exists := c.entries[entry.key]; exists {
+		c.remove(element)
+	}
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	c.bytes += int64(len(entry.body))
+
+	for c.lru.Len() > c.cfg.MaxEntries || c.bytes > c.cfg.MaxBytes {
+		c.remove(c.lru.Back())
+		c.evictions.Inc()
+	}
+}
+
+// remove drops an entry; callers must hold mu
+func (c *ResponseCache) remove(element *list.Element) {
+	entry := c.lru.Remove(element).(*cachedResponse)
+	delete(c.entries, entry.key)
+	c.bytes -= int64(len(entry.body))
+}
+
+// responseCacheKey hashes everything that identifies a cacheable request
+func responseCacheKey(apiKey, tag string, r *http.Request, body []byte) string {
+	h := sha256.New()
+	for _, part := range []string{apiKey, tag, r.Method, r.URL.Path, r.URL.Query().Encode()} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	h.Write(canonicalBody(body))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// canonicalBody re-encodes JSON bodies with sorted keys and no insignificant
+// whitespace, so equivalent requests share a cache entry
+func canonicalBody(body []byte) []byte {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return body
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return body
+	}
+	canonical, err := json.Marshal(value)
+	if err != nil {
+		return body
+	}
+	return canonical
+}
+
+// cacheControl parses a Cache-Control header into directives
+func cacheControl(h http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, value := range h.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if name != "" {
+				directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+			}
+		}
+	}
+	return directives
+}
+
+// serveCached answers a request from the response cache. On a miss it returns
+// false and records the key so the node's response can be stored.
+func (p *ProxyServer) serveCached(w http.ResponseWriter, r *http.Request, apiKey string) bool {
+	policy := p.responses.policyFor(r)
+	if policy == nil {
+		return false
+	}
+	info := getRequestInfo(r)
+
+	directives := cacheControl(r.Header)
+	_, noStore := directives["no-store"]
+	if bypass := r.Header.Get(p.responses.cfg.BypassHeader); noStore || (bypass != "" && bypass != "0" && bypass != "false") {
+		p.responses.bypasses.Inc()
+		w.Header().Set("X-C3-Cache", "BYPASS")
+		return false
+	}
+
+	// Bodies too large to cache are streamed to the node as usual
+	if r.ContentLength > p.responses.cfg.MaxEntryBytes {
+		p.responses.bypasses.Inc()
+		w.Header().Set("X-C3-Cache", "BYPASS")
+		return false
+	}
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = io.ReadAll(io.LimitReader(r.Body, p.responses.cfg.MaxEntryBytes+1))
+		if err != nil {
+			r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), &errReader{err}))
+			return false
+		}
+		if int64(len(body)) > p.responses.cfg.MaxEntryBytes {
+			r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+			p.responses.bypasses.Inc()
+			w.Header().Set("X-C3-Cache", "BYPASS")
+			return false
+		}
+		r.Body = http.NoBody
+		if len(body) > 0 {
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		r.ContentLength = int64(len(body))
+	}
+
+	key := responseCacheKey(apiKey, info.Tag, r, body)
+	if _, noCache := directives["no-cache"]; !noCache {
+		if entry := p.responses.get(key); entry != nil {
+			p.responses.hits.Inc()
+			p.logger.Debug("🗄️  Response cache hit for %s %s (tag %s)", r.Method, r.URL.Path, info.Tag)
+			for name, values := range entry.header {
+				w.Header()[name] = values
+			}
+			w.Header().Set("X-C3-Cache", "HIT")
+			w.Header().Set("Age", strconv.Itoa(int(time.Since(entry.stored).Seconds())))
+			w.WriteHeader(entry.status)
+			w.Write(entry.body)
+			return true
+		}
+	}
+
+	p.responses.misses.Inc()
+	w.Header().Set("X-C3-Cache", "MISS")
+	info.CacheKey = key
+	info.CacheTTL = time.Duration(policy.TTL)
+	return false
+}
+
+// errReader returns err once the buffered part of a body has been read
+type errReader struct{ err error }
+
+func (e *errReader) Read([]byte) (int, error) { return 0, e.err }
+
+// cacheRecorder passes a response through to the client while keeping a copy
+// for the response cache
+type cacheRecorder struct {
+	http.ResponseWriter
+	status   int
+	header   http.Header
+	body     bytes.Buffer
+	maxBytes int64
+	overflow bool
+}
+
+func (c *cacheRecorder) WriteHeader(status int) {
+	if c.status == 0 {
+		c.status = status
+		c.header = c.ResponseWriter.Header().Clone()
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *cacheRecorder) Write(b []byte) (int, error) {
+	if c.status == 0 {
+		c.WriteHeader(http.StatusOK)
+	}
+	if !c.overflow {
+		if int64(c.body.Len()+len(b)) > c.maxBytes {
+			c.overflow = true
+			c.body = bytes.Buffer{}
+		} else {
+			c.body.Write(b)
+		}
+	}
+	return c.ResponseWriter.Write(b)
+}
+
+func (c *cacheRecorder) Flush() {
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (c *cacheRecorder) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// handleCacheable proxies a request and stores a complete, cacheable
+// response under the key recorded by serveCached
+func (p *ProxyServer) handleCacheable(w http.ResponseWriter, r *http.Request, node, apiKey string) {
+	info := getRequestInfo(r)
+	if info.CacheKey == "" {
+		p.HandleProxyRequest(w, r, node, apiKey)
+		return
+	}
+
+	recorder := &cacheRecorder{ResponseWriter: w, maxBytes: p.responses.cfg.MaxEntryBytes}
+	p.HandleProxyRequest(recorder, r, node, apiKey)
+
+	if recorder.status != http.StatusOK || recorder.overflow || info.Truncated || r.Context().Err() != nil {
+		return
+	}
+	ttl, err := responseTTL(recorder.header, info.CacheTTL)
+	if err != nil {
+		p.logger.Debug("🗄️  Not caching response for %s %s: %v", r.Method, r.URL.Path, err)
+		return
+	}
+
+	// Routing headers describe this request only; a hit is served by no node
+	header := recorder.header
+	for _, name := range append([]string{"X-C3-Cache", "Date", "Age", p.routingHeaders.WorkloadHeader}, routingHeaderNames...) {
+		header.Del(name)
+	}
+	removeCORSHeaders(header)
+
+	now := time.Now()
+	p.responses.put(&cachedResponse{
+		key:     info.CacheKey,
+		status:  recorder.status,
+		header:  header,
+		body:    bytes.Clone(recorder.body.Bytes()),
+		stored:  now,
+		expires: now.Add(ttl),
+	})
+	p.logger.Debug("🗄️  Cached response for %s %s (tag %s) for %v", r.Method, r.URL.Path, info.Tag, ttl)
+}
+
+// responseTTL applies the node's Cache-Control to the tag's TTL. Responses
+// marked no-store, no-cache or private, or setting cookies, are not cached.
+func responseTTL(h http.Header, ttl time.Duration) (time.Duration, error) {
+	if h.Get("Set-Cookie") != "" {
+		return 0, fmt.Errorf("response sets a cookie")
+	}
+	directives := cacheControl(h)
+	for _, name := range []string{"no-store", "no-cache", "private"} {
+		if _, set := directives[name]; set {
+			return 0, fmt.Errorf("response is marked %s", name)
+		}
+	}
+	for _, name := range []string{"s-maxage", "max-age"} {
+		if value, set := directives[name]; set {
+			seconds, err := strconv.Atoi(value)
+			if err != nil {
+				continue
+			}
+			if seconds <= 0 {
+				return 0, fmt.Errorf("response is marked %s=%d", name, seconds)
+			}
+			if maxAge := time.Duration(seconds) * time.Second; maxAge < ttl {
+				ttl = maxAge
+			}
+			break
+		}
+	}
+	return ttl, nil
+}
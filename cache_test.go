@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestResponseCacheKeyCanonicalizesBody(t *testing.T) {
+	key := func(tag, target, body string) string {
+		r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		return responseCacheKey("key", tag, r, []byte(body))
+	}
+
+	base := key("llama", "/v1/chat?a=1&b=2", `{"model": "m", "messages": [1, 2]}`)
+	if got := key("llama", "/v1/chat?b=2&a=1", "{\"messages\":[1,2],\n\"model\":\"m\"}"); got != base {
+		t.Error("reordered keys, whitespace and query order changed the cache key")
+	}
+
+	for name, got := range map[string]string{
+		"body":  key("llama", "/v1/chat?a=1&b=2", `{"model": "m", "messages": [2, 1]}`),
+		"path":  key("llama", "/v1/completions?a=1&b=2", `{"model": "m", "messages": [1, 2]}`),
+		"query": key("llama", "/v1/chat?a=1&b=3", `{"model": "m", "messages": [1, 2]}`),
+		"tag":   key("mistral", "/v1/chat?a=1&b=2", `{"model": "m", "messages": [1, 2]}`),
+	} {
+		if got == base {
+			t.Errorf("a different %s gave the same cache key", name)
+		}
+	}
+
+	if got := string(canonicalBody([]byte("not json"))); got != "not json" {
+		t.Errorf("canonicalBody changed a non-JSON body to %q", got)
+	}
+}
+
+func TestResponseTTL(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  http.Header
+		want    time.Duration
+		wantErr bool
+	}{
+		{"no directives", http.Header{}, time.Minute, false},
+		{"no-store", http.Header{"Cache-Control": {"no-store"}}, 0, true},
+		{"no-cache", http.Header{"Cache-Control": {"no-cache"}}, 0, true},
+		{"private", http.Header{"Cache-Control": {"private, max-age=30"}}, 0, true},
+		{"cookie", http.Header{"Set-Cookie": {"session=1"}}, 0, true},
+		{"shorter max-age", http.Header{"Cache-Control": {"public, max-age=30"}}, 30 * time.Second, false},
+		{"longer max-age", http.Header{"Cache-Control": {"max-age=3600"}}, time.Minute, false},
+		{"max-age=0", http.Header{"Cache-Control": {"max-age=0"}}, 0, true},
+		{"s-maxage first", http.Header{"Cache-Control": {"max-age=10, s-maxage=20"}}, 20 * time.Second, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := responseTTL(tt.header, time.Minute)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("responseTTL = %v, %v, want %v (error %v)", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResponseCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	p := newTestProxy(t, map[string]string{"RESPONSE_CACHE": `{"max_entries": 2, "max_bytes": 10, "tags": {"llama": {}}}`})
+	cache := p.responses
+	put := func(key, body string) {
+		cache.put(&cachedResponse{key: key, status: http.StatusOK, body: []byte(body), expires: time.Now().Add(time.Minute)})
+	}
+
+	put("a", "1")
+	put("b", "2")
+	cache.get("a") // a is now more recently used than b
+	put("c", "3")
+	if cache.get("b") != nil {
+		t.Error("least recently used entry b was kept past max_entries")
+	}
+	if cache.get("a") == nil || cache.get("c") == nil {
+		t.Error("recently used entries were evicted")
+	}
+
+	put("d", "0123456789")
+	if cache.lru.Len() != 1 || cache.get("d") == nil || cache.bytes != 10 {
+		t.Errorf("after exceeding max_bytes: %d entries, %d bytes, want only d", cache.lru.Len(), cache.bytes)
+	}
+
+	cache.put(&cachedResponse{key: "old", expires: time.Now().Add(-time.Second)})
+	if cache.get("old") != nil {
+		t.Error("get returned an expired entry")
+	}
+}
+
+// cachedProxy routes tag llama to a node that counts its requests
+func cachedProxy(t *testing.T) (p *ProxyServer, calls *atomic.Int32) {
+	t.Helper()
+	calls = new(atomic.Int32)
+	node := newTestNode(t, func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		fmt.Fprintf(w, "response %d", n)
+	})
+	p = newTestProxy(t, map[string]string{
+		"RESPONSE_CACHE":  `{"tags": {"llama": {"ttl": "1m"}}}`,
+		"ROUTING_HEADERS": "always",
+	})
+	seedWorkloads(p, testKey, testWorkload(node, "w-1", "llama"))
+	return p, calls
+}
+
+func TestResponseCacheHitOmitsRoutingHeaders(t *testing.T) {
+	p, calls := cachedProxy(t)
+	send := func() *httptest.ResponseRecorder {
+		return doRequest(p, httptest.NewRequest(http.MethodPost, "/tags/llama/v1/embeddings", strings.NewReader(`{"input": "hi"}`)))
+	}
+
+	miss := send()
+	if miss.Header().Get("X-C3-Cache") != "MISS" || miss.Header().Get("X-C3-Node") == "" || miss.Header().Get("X-C3-Workload") != "w-1" {
+		t.Fatalf("first response headers = %v, want a MISS with routing headers", miss.Header())
+	}
+
+	hit := send()
+	if hit.Header().Get("X-C3-Cache") != "HIT" || hit.Body.String() != "response 1" || calls.Load() != 1 {
+		t.Fatalf("second response = %q (%s) after %d node calls, want a HIT of the first", hit.Body.String(), hit.Header().Get("X-C3-Cache"), calls.Load())
+	}
+	for _, name := range append([]string{"X-C3-Workload"}, routingHeaderNames...) {
+		if value := hit.Header().Get(name); value != "" {
+			t.Errorf("cache hit carries %s: %s", name, value)
+		}
+	}
+}
+
+func TestResponseCacheBypass(t *testing.T) {
+	p, calls := cachedProxy(t)
+
+	for name, header := range map[string][2]string{
+		"no-store":      {"Cache-Control", "no-store"},
+		"bypass header": {"X-C3-Cache-Bypass", "1"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/tags/llama/v1/models", nil)
+		r.Header.Set(header[0], header[1])
+		if got := doRequest(p, r).Header().Get("X-C3-Cache"); got != "BYPASS" {
+			t.Errorf("%s: X-C3-Cache = %q, want BYPASS", name, got)
+		}
+	}
+	if p.responses.lru.Len() != 0 {
+		t.Errorf("bypassed responses were stored")
+	}
+
+	// no-cache skips the lookup but stores the fresh response
+	doRequest(p, httptest.NewRequest(http.MethodGet, "/tags/llama/v1/models", nil))
+	r := httptest.NewRequest(http.MethodGet, "/tags/llama/v1/models", nil)
+	r.Header.Set("Cache-Control", "no-cache")
+	if got := doRequest(p, r).Body.String(); got != "response 4" {
+		t.Errorf("no-cache request got %q, want a fresh response", got)
+	}
+	if got := doRequest(p, httptest.NewRequest(http.MethodGet, "/tags/llama/v1/models", nil)).Body.String(); got != "response 4" || calls.Load() != 4 {
+		t.Errorf("after no-cache got %q, want the refreshed response 4", got)
+	}
+}
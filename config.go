@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// getEnvBool reads a boolean environment variable, falling back to def when unset or invalid
+func getEnvBool(name string, def bool) bool {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("⚠️  Invalid value %q for %s, using default", value, name)
+		return def
+	}
+	return parsed
+}
+
+// getEnvInt reads an integer environment variable, falling back to def when unset or invalid
+func getEnvInt(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("⚠️  Invalid value %q for %s, using default", value, name)
+		return def
+	}
+	return parsed
+}
+
+// getEnvDuration reads a duration environment variable (e.g. "30s", "5m"),
+// falling back to def when unset or invalid
+func getEnvDuration(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("⚠️  Invalid value %q for %s, using default", value, name)
+		return def
+	}
+	return parsed
+}
+
+// getEnvList reads a comma-separated environment variable, dropping empty entries
+func getEnvList(name string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(name), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// loadJSONEnv decodes JSON configuration from the named environment variable,
+// or from the file referenced by NAME_FILE. It reports whether any was found.
+func loadJSONEnv(name string, v interface{}) (bool, error) {
+	data := []byte(os.Getenv(name))
+	if len(data) == 0 {
+		path := os.Getenv(name + "_FILE")
+		if path == "" {
+			return false, nil
+		}
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return false, fmt.Errorf("failed to read %s: %v", path, err)
+		}
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, fmt.Errorf("invalid %s: %v", name, err)
+	}
+	return true, nil
+}
+
+// Duration is a time.Duration that decodes from JSON strings such as "30s"
+// or from a number of seconds
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case float64:
+		*d = Duration(time.Duration(v * float64(time.Second)))
+	case string:
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*d = Duration(parsed)
+	default:
+		return fmt.Errorf("invalid duration %s", string(data))
+	}
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
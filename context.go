@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+)
+
+// requestInfo carries routing metadata for a single proxied request
+type requestInfo struct {
+	ID       string
+	Tag      string
+	Route    string
+	Balancer string // how the node was chosen
+	Attempts int    // requests sent to nodes
+	Start    time.Time
+
+	CacheKey  string // response cache key for a cacheable miss
+	CacheTTL  time.Duration
+	Truncated bool // the response stream to the client ended early
+}
+
+type requestInfoKey struct{}
+
+// withRequestInfo attaches routing metadata to the request context
+func withRequestInfo(r *http.Request, info *requestInfo) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info))
+}
+
+// getRequestInfo returns the routing metadata for a request, creating an
+// empty one for requests that did not pass through ProxyHandler
+func getRequestInfo(r *http.Request) *requestInfo {
+	if info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+		return info
+	}
+	return &requestInfo{Start: time.Now()}
+}
+
+// newRequestID returns the client-supplied X-Request-ID or a random one
+func newRequestID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-ID"); id != "" && len(id) <= 128 {
+		return id
+	}
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return time.Now().Format("20060102150405.000000000")
+	}
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// CORSConfig controls the CORS headers the proxy answers browser clients with
+type CORSConfig struct {
+	Enabled          bool
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           int
+}
+
+func loadCORSConfig(logger *Logger) (CORSConfig, error) {
+	cfg := CORSConfig{
+		AllowedOrigins:   getEnvList("CORS_ALLOWED_ORIGINS"),
+		AllowedMethods:   getEnvList("CORS_ALLOWED_METHODS"),
+		AllowedHeaders:   getEnvList("CORS_ALLOWED_HEADERS"),
+		ExposedHeaders:   getEnvList("CORS_EXPOSED_HEADERS"),
+		AllowCredentials: getEnvBool("CORS_ALLOW_CREDENTIALS", false),
+		MaxAge:           getEnvInt("CORS_MAX_AGE", 600),
+	}
+	cfg.Enabled = getEnvBool("CORS_ENABLED", len(cfg.AllowedOrigins) > 0)
+	if !cfg.Enabled {
+		return cfg, nil
+	}
+
+	if len(cfg.AllowedOrigins) == 0 {
+		cfg.AllowedOrigins = []string{"*"}
+	}
+	if len(cfg.AllowedMethods) == 0 {
+		cfg.AllowedMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+	}
+	if len(cfg.AllowedHeaders) == 0 {
+		cfg.AllowedHeaders = []string{"Authorization", "Content-Type", "X-C3-API-KEY", "X-Request-ID"}
+	}
+	// Reflecting any origin with credentials would let every site make
+	// authenticated requests on a user's behalf
+	if cfg.AllowCredentials && cfg.allowsAnyOrigin() {
+		return cfg, fmt.Errorf("CORS_ALLOW_CREDENTIALS cannot be used with a wildcard CORS_ALLOWED_ORIGINS; list the allowed origins")
+	}
+
+	logger.Info("🌍 CORS enabled for origins: %v", cfg.AllowedOrigins)
+	return cfg, nil
+}
+
+func (c CORSConfig) allowsAnyOrigin() bool {
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// allowOrigin reports whether a request origin is permitted. Entries may use
+// a leading wildcard subdomain, e.g. "https://*.example.com".
+func (c CORSConfig) allowOrigin(origin string) bool {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		if i := strings.Index(allowed, "*."); i >= 0 {
+			prefix, suffix := allowed[:i], allowed[i+1:]
+			if strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) &&
+				len(origin) > len(prefix)+len(suffix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// allowMethod reports whether a preflight's requested method is permitted
+func (c CORSConfig) allowMethod(method string) bool {
+	for _, allowed := range c.AllowedMethods {
+		if allowed == "*" || strings.EqualFold(allowed, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPreflight reports whether the request is a CORS preflight request
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions &&
+		r.Header.Get("Origin") != "" &&
+		r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// applyCORS sets CORS response headers for an allowed origin. For preflight
+// requests it also answers the allowed methods and headers.
+// It returns false when the origin is not allowed.
+func (p *ProxyServer) applyCORS(w http.ResponseWriter, r *http.Request) bool {
+	h := w.Header()
+	h.Add("Vary", "Origin")
+
+	origin := r.Header.Get("Origin")
+	if origin == "" || !p.cors.allowOrigin(origin) {
+		return false
+	}
+
+	if p.cors.allowsAnyOrigin() {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if p.cors.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	if !isPreflight(r) {
+		if len(p.cors.ExposedHeaders) > 0 {
+			h.Set("Access-Control-Expose-Headers", strings.Join(p.cors.ExposedHeaders, ", "))
+		}
+		return true
+	}
+
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+	h.Set("Access-Control-Allow-Methods", strings.Join(p.cors.AllowedMethods, ", "))
+
+	allowedHeaders := strings.Join(p.cors.AllowedHeaders, ", ")
+	if allowedHeaders == "*" {
+		// Reflect the requested headers so credentialed requests work too
+		allowedHeaders = r.Header.Get("Access-Control-Request-Headers")
+	}
+	if allowedHeaders != "" {
+		h.Set("Access-Control-Allow-Headers", allowedHeaders)
+	}
+	if p.cors.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(p.cors.MaxAge))
+	}
+	return true
+}
+
+// handlePreflight answers a CORS preflight locally without contacting a node
+func (p *ProxyServer) handlePreflight(w http.ResponseWriter, r *http.Request) {
+	if method := r.Header.Get("Access-Control-Request-Method"); !p.cors.allowMethod(method) {
+		p.logger.Debug("🚫 CORS preflight rejected for method %s", method)
+		w.Header().Add("Vary", "Origin")
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if !p.applyCORS(w, r) {
+		p.logger.Debug("🚫 CORS preflight rejected for origin %s", r.Header.Get("Origin"))
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	p.logger.Debug("✈️  CORS preflight answered for origin %s", r.Header.Get("Origin"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeCORSHeaders drops CORS headers returned by a node so the proxy's own
+// policy is the only one the browser sees
+func removeCORSHeaders(h http.Header) {
+	for name := range h {
+		if strings.HasPrefix(name, "Access-Control-") {
+			h.Del(name)
+		}
+	}
+}
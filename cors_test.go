@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func preflight(origin, method string) *http.Request {
+	r := httptest.NewRequest(http.MethodOptions, "/tags/llama/v1/chat/completions", nil)
+	r.Header.Set("Origin", origin)
+	r.Header.Set("Access-Control-Request-Method", method)
+	r.Header.Set("Access-Control-Request-Headers", "content-type")
+	return r
+}
+
+func TestCORSPreflight(t *testing.T) {
+	p := newTestProxy(t, map[string]string{
+		"CORS_ALLOWED_ORIGINS":   "https://app.example.com,https://*.example.org",
+		"CORS_ALLOWED_METHODS":   "GET,POST",
+		"CORS_ALLOW_CREDENTIALS": "true",
+	})
+
+	tests := []struct {
+		name, origin, method string
+		wantStatus           int
+	}{
+		{"listed origin", "https://app.example.com", "POST", http.StatusNoContent},
+		{"wildcard subdomain", "https://a.example.org", "GET", http.StatusNoContent},
+		{"bare wildcard domain", "https://example.org", "GET", http.StatusForbidden},
+		{"unlisted origin", "https://evil.example", "POST", http.StatusForbidden},
+		{"unlisted method", "https://app.example.com", "DELETE", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Preflights are answered without an API key
+			w := httptest.NewRecorder()
+			p.ProxyHandler(w, preflight(tt.origin, tt.method))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			allowOrigin := w.Header().Get("Access-Control-Allow-Origin")
+			if tt.wantStatus != http.StatusNoContent {
+				if allowOrigin != "" {
+					t.Errorf("rejected preflight allowed origin %s", allowOrigin)
+				}
+				return
+			}
+			if allowOrigin != tt.origin || w.Header().Get("Access-Control-Allow-Credentials") != "true" {
+				t.Errorf("allow origin = %q, credentials = %q, want the reflected origin with credentials",
+					allowOrigin, w.Header().Get("Access-Control-Allow-Credentials"))
+			}
+			if got := w.Header().Get("Access-Control-Allow-Methods"); got != "GET, POST" {
+				t.Errorf("allow methods = %q", got)
+			}
+		})
+	}
+}
+
+func TestCORSWildcardOrigin(t *testing.T) {
+	p := newTestProxy(t, map[string]string{"CORS_ENABLED": "true", "CORS_ALLOWED_HEADERS": "*"})
+
+	w := httptest.NewRecorder()
+	p.ProxyHandler(w, preflight("https://any.example", "POST"))
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Fatalf("preflight = %d with origin %q, want 204 with *", w.Code, w.Header().Get("Access-Control-Allow-Origin"))
+	}
+	if got := w.Header().Get("Access-Control-Allow-Headers"); got != "content-type" {
+		t.Errorf("allow headers = %q, want the requested headers reflected", got)
+	}
+}
+
+func TestCORSCredentialsNeedListedOrigins(t *testing.T) {
+	t.Setenv("API_URL", "http://unused.invalid")
+	t.Setenv("CORS_ALLOWED_ORIGINS", "*")
+	t.Setenv("CORS_ALLOW_CREDENTIALS", "true")
+	if _, err := NewProxyServer(); err == nil {
+		t.Error("NewProxyServer accepted credentials with a wildcard origin")
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+// Nodes with at least this many recent requests and this error rate are shown as failing
+const (
+	failingMinRequests = 5
+	failingErrorRate   = 0.5
+)
+
+type dashboardNode struct {
+	Node          string `json:"node"`
+	InFlight      int    `json:"in_flight"`
+	Health        string `json:"health"`
+	TTFBEWMAMs    int64  `json:"ttfb_ewma_ms"`
+	ThroughputBps int64  `json:"throughput_bps"`
+	nodeTraffic
+}
+
+type dashboardTag struct {
+	Tag   string          `json:"tag"`
+	Nodes []dashboardNode `json:"nodes"`
+}
+
+type dashboardKey struct {
+	Hash string         `json:"hash"`
+	Tags []dashboardTag `json:"tags"`
+}
+
+type dashboardSnapshot struct {
+	Time        time.Time      `json:"time"`
+	Replica     string         `json:"replica"`
+	WindowSecs  int            `json:"window_secs"`
+	Keys        []dashboardKey `json:"keys"`
+	Draining    []string       `json:"draining"`
+	TrackedKeys int            `json:"tracked_keys"`
+}
+
+// nodeHealth classifies a node for the dashboard; callers must hold cacheLock
+func (p *ProxyServer) nodeHealth(apiKey, node string, traffic nodeTraffic) string {
+	switch {
+	case p.draining.isDraining(node):
+		return "draining"
+	case p.isExpiring(apiKey, node):
+		return "expiring"
+	case traffic.Requests >= failingMinRequests && traffic.ErrorRate >= failingErrorRate:
+		return "failing"
+	default:
+		return "healthy"
+	}
+}
+
+// dashboardSnapshot collects per key and tag node state for the dashboard
+func (p *ProxyServer) dashboardSnapshot() dashboardSnapshot {
+	snapshot := dashboardSnapshot{
+		Time:       time.Now(),
+		Replica:    replicaID(),
+		WindowSecs: int(statsWindow.Seconds()),
+		Keys:       make([]dashboardKey, 0),
+		Draining:   make([]string, 0),
+	}
+	for node := range p.draining.list() {
+		snapshot.Draining = append(snapshot.Draining, node)
+	}
+	sort.Strings(snapshot.Draining)
+
+	keys := p.trackedKeys()
+	snapshot.TrackedKeys = len(keys)
+	for _, apiKey := range keys {
+		peers, err := p.state.PeerInFlight(apiKey)
+		if err != nil {
+			p.logger.Debug("⚠️  Failed to read peer in-flight counts for dashboard: %v", err)
+		}
+
+		p.requestLock.RLock()
+		inFlight := make(map[string]int)
+		for node, count := range p.inFlightRequests[apiKey] {
+			inFlight[node] = count
+		}
+		p.requestLock.RUnlock()
+
+		key := dashboardKey{Hash: hashKey(apiKey), Tags: make([]dashboardTag, 0)}
+		p.cacheLock.RLock()
+		for tag, nodes := range p.tagMappings[apiKey] {
+			entry := dashboardTag{Tag: tag, Nodes: make([]dashboardNode, 0, len(nodes))}
+			for _, node := range nodes {
+				traffic := p.traffic.recent(node)
+				latency, _ := p.latency.snapshot(node)
+				entry.Nodes = append(entry.Nodes, dashboardNode{
+					Node:          node,
+					InFlight:      inFlight[node] + peers[node],
+					Health:        p.nodeHealth(apiKey, node, traffic),
+					TTFBEWMAMs:    latency.ttfb.Milliseconds(),
+					ThroughputBps: int64(latency.throughput),
+					nodeTraffic:   traffic,
+				})
+			}
+			key.Tags = append(key.Tags, entry)
+		}
+		p.cacheLock.RUnlock()
+
+		sort.Slice(key.Tags, func(i, j int) bool { return key.Tags[i].Tag < key.Tags[j].Tag })
+		snapshot.Keys = append(snapshot.Keys, key)
+	}
+	sort.Slice(snapshot.Keys, func(i, j int) bool { return snapshot.Keys[i].Hash < snapshot.Keys[j].Hash })
+	return snapshot
+}
+
+// DashboardHandler serves the dashboard page. The page holds no data itself;
+// it asks for the admin token and reads the event stream with it.
+func (p *ProxyServer) DashboardHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+	w.Write(dashboardHTML)
+}
+
+// DashboardEventsHandler streams dashboard snapshots as server-sent events
+func (p *ProxyServer) DashboardEventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		p.writeError(w, r, newProxyError(http.StatusInternalServerError, CodeInternalError, "streaming not supported"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(p.dashboardTick)
+	defer ticker.Stop()
+
+	for {
+		data, err := json.Marshal(p.dashboardSnapshot())
+		if err != nil {
+			p.logger.Error("Failed to encode dashboard snapshot: %v", err)
+			return
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-ticker.C:
+		case <-r.Context().Done():
+			return
+		case <-p.shutdown:
+			return
+		}
+	}
+}
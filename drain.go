@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxDrainWait caps how long a drain status request may long-poll
+const maxDrainWait = 10 * time.Minute
+
+// drainPollInterval is how often a long-polling drain status request rechecks
+const drainPollInterval = 250 * time.Millisecond
+
+// drainSet tracks nodes that receive no new traffic while in-flight requests finish
+type drainSet struct {
+	mu    sync.RWMutex
+	nodes map[string]time.Time // node -> drain start
+}
+
+func newDrainSet() *drainSet {
+	return &drainSet{nodes: make(map[string]time.Time)}
+}
+
+func (d *drainSet) isDraining(node string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	_, draining := d.nodes[node]
+	return draining
+}
+
+func (d *drainSet) add(node string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, draining := d.nodes[node]; !draining {
+		d.nodes[node] = time.Now()
+	}
+}
+
+func (d *drainSet) remove(node string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.nodes, node)
+}
+
+func (d *drainSet) list() map[string]time.Time {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	nodes := make(map[string]time.Time, len(d.nodes))
+	for node, since := range d.nodes {
+		nodes[node] = since
+	}
+	return nodes
+}
+
+// withoutDraining removes draining nodes from a candidate list
+func (p *ProxyServer) withoutDraining(nodes []string) []string {
+	active := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		if p.draining.isDraining(node) {
+			p.logger.Debug("🚰 Skipping draining node %s", node)
+			continue
+		}
+		active = append(active, node)
+	}
+	return active
+}
+
+// nodeInFlight sums a node's local in-flight requests across all API keys
+func (p *ProxyServer) nodeInFlight(node string) int {
+	p.requestLock.RLock()
+	defer p.requestLock.RUnlock()
+
+	total := 0
+	for _, nodes := range p.inFlightRequests {
+		total += nodes[node]
+	}
+	return total
+}
+
+// nodesWithTag returns every node carrying a tag for any tracked API key
+func (p *ProxyServer) nodesWithTag(tag string) []string {
+	p.cacheLock.RLock()
+	defer p.cacheLock.RUnlock()
+
+	seen := make(map[string]bool)
+	var nodes []string
+	for _, tagMap := range p.tagMappings {
+		for _, node := range tagMap[tag] {
+			if !seen[node] {
+				seen[node] = true
+				nodes = append(nodes, node)
+			}
+		}
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// nodeDrained logs when a draining node's last in-flight request finishes
+func (p *ProxyServer) nodeDrained(node string, count int) {
+	if count > 0 || !p.draining.isDraining(node) {
+		return
+	}
+	if p.nodeInFlight(node) == 0 {
+		p.logger.Info("🚰 Draining node %s has no in-flight requests left", node)
+	}
+}
+
+// drainStatus describes a draining node for the admin API
+type drainStatus struct {
+	Node     string    `json:"node"`
+	Since    time.Time `json:"since"`
+	InFlight int       `json:"in_flight"`
+	Drained  bool      `json:"drained"`
+}
+
+func (p *ProxyServer) drainStatusFor(node string, since time.Time) drainStatus {
+	inFlight := p.nodeInFlight(node)
+	return drainStatus{Node: node, Since: since, InFlight: inFlight, Drained: inFlight == 0}
+}
+
+// drainRequest selects nodes by name or by tag
+type drainRequest struct {
+	Node string `json:"node,omitempty"`
+	Tag  string `json:"tag,omitempty"`
+}
+
+// DrainHandler serves the drain admin endpoints:
+//
+//	GET    /admin/drain              list draining nodes
+//	GET    /admin/drain/{node}?wait= status of one node, optionally waiting until drained
+//	POST   /admin/drain              start draining {"node": ...} or {"tag": ...}
+//	DELETE /admin/drain              stop draining {"node": ...} or {"tag": ...}
+func (p *ProxyServer) DrainHandler(w http.ResponseWriter, r *http.Request) {
+	if node := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/drain"), "/"); node != "" {
+		if r.Method != http.MethodGet {
+			p.writeError(w, r, newProxyError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Use GET for drain status"))
+			return
+		}
+		p.waitForDrain(w, r, node)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		drains := p.draining.list()
+		statuses := make([]drainStatus, 0, len(drains))
+		for node, since := range drains {
+			statuses = append(statuses, p.drainStatusFor(node, since))
+		}
+		sort.Slice(statuses, func(i, j int) bool { return statuses[i].Node < statuses[j].Node })
+		writeJSON(w, http.StatusOK, statuses)
+
+	case http.MethodPost, http.MethodDelete:
+		var req drainRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.Node == "") == (req.Tag == "") {
+			p.writeError(w, r, newProxyError(http.StatusBadRequest, CodeInvalidRequest,
+				"Body must be {\"node\": ...} or {\"tag\": ...}"))
+			return
+		}
+
+		nodes := []string{req.Node}
+		if req.Tag != "" {
+			nodes = p.nodesWithTag(req.Tag)
+			if len(nodes) == 0 {
+				p.writeError(w, r, newProxyError(http.StatusNotFound, CodeUnknownTag, "no nodes found for tag: %s", req.Tag))
+				return
+			}
+		}
+
+		statuses := make([]drainStatus, 0, len(nodes))
+		for _, node := range nodes {
+			if r.Method == http.MethodPost {
+				p.draining.add(node)
+				p.logger.Info("🚰 Draining node %s (%d in-flight requests)", node, p.nodeInFlight(node))
+				statuses = append(statuses, p.drainStatusFor(node, p.draining.list()[node]))
+			} else {
+				p.draining.remove(node)
+				p.logger.Info("🚿 Node %s is no longer draining", node)
+				statuses = append(statuses, drainStatus{Node: node, InFlight: p.nodeInFlight(node)})
+			}
+		}
+		writeJSON(w, http.StatusOK, statuses)
+
+	default:
+		p.writeError(w, r, newProxyError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Unsupported method"))
+	}
+}
+
+// waitForDrain reports a draining node's status. With ?wait=<duration> it
+// blocks until the node has no in-flight requests or the wait expires.
+func (p *ProxyServer) waitForDrain(w http.ResponseWriter, r *http.Request, node string) {
+	since, draining := p.draining.list()[node]
+	if !draining {
+		p.writeError(w, r, newProxyError(http.StatusNotFound, CodeInvalidRequest, "node %s is not draining", node))
+		return
+	}
+
+	wait, _ := time.ParseDuration(r.URL.Query().Get("wait"))
+	if wait > maxDrainWait {
+		wait = maxDrainWait
+	}
+
+	if wait > 0 {
+		timeout := time.NewTimer(wait)
+		defer timeout.Stop()
+		ticker := time.NewTicker(drainPollInterval)
+		defer ticker.Stop()
+
+	poll:
+		for p.nodeInFlight(node) > 0 && p.draining.isDraining(node) {
+			select {
+			case <-ticker.C:
+			case <-timeout.C:
+				break poll
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+
+	writeJSON(w, http.StatusOK, p.drainStatusFor(node, since))
+}
+
+// writeJSON sends v as a JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
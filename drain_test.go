@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func drainRequestFor(method, body string) *http.Request {
+	return httptest.NewRequest(method, "/admin/drain", strings.NewReader(body))
+}
+
+func drainStatuses(t *testing.T, w *httptest.ResponseRecorder) []drainStatus {
+	t.Helper()
+	var statuses []drainStatus
+	if err := json.NewDecoder(w.Body).Decode(&statuses); err != nil {
+		t.Fatalf("decoding drain statuses: %v", err)
+	}
+	return statuses
+}
+
+func TestDrainingNodesGetNoNewRequests(t *testing.T) {
+	served := make(chan string, 10)
+	handler := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) { served <- name }
+	}
+	nodeA := newTestNode(t, handler("a"))
+	nodeB := newTestNode(t, handler("b"))
+	p := newTestProxy(t, nil)
+	seedWorkloads(p, testKey, testWorkload(nodeA, "w-1", "llama"), testWorkload(nodeB, "w-2", "llama"))
+
+	p.draining.add(nodeA)
+	for i := 0; i < 4; i++ {
+		if w := doRequest(p, httptest.NewRequest(http.MethodGet, "/tags/llama/v1/models", nil)); w.Code != http.StatusOK {
+			t.Fatalf("request %d = %d, want 200", i, w.Code)
+		}
+		if node := <-served; node != "b" {
+			t.Errorf("request %d went to the draining node", i)
+		}
+	}
+
+	p.draining.add(nodeB)
+	w := doRequest(p, httptest.NewRequest(http.MethodGet, "/tags/llama/v1/models", nil))
+	if w.Code != http.StatusServiceUnavailable || errorCode(t, w) != CodeNodeDraining {
+		t.Errorf("all nodes draining = %d, want 503 node_draining", w.Code)
+	}
+}
+
+func TestDrainHandlerByTag(t *testing.T) {
+	p := newTestProxy(t, nil)
+	seedWorkloads(p, testKey, testWorkload("node-a", "w-1", "llama"), testWorkload("node-b", "w-2", "llama"),
+		testWorkload("node-c", "w-3", "bge"))
+	p.TrackRequest(testKey, "node-a", 1)
+
+	w := httptest.NewRecorder()
+	p.DrainHandler(w, drainRequestFor(http.MethodPost, `{"tag": "llama"}`))
+	statuses := drainStatuses(t, w)
+	if len(statuses) != 2 || statuses[0].Node != "node-a" || statuses[0].InFlight != 1 || statuses[0].Drained || !statuses[1].Drained {
+		t.Fatalf("draining tag llama = %+v", statuses)
+	}
+	if p.draining.isDraining("node-c") {
+		t.Error("a node without the tag is draining")
+	}
+
+	w = httptest.NewRecorder()
+	p.DrainHandler(w, drainRequestFor(http.MethodDelete, `{"node": "node-b"}`))
+	w = httptest.NewRecorder()
+	p.DrainHandler(w, drainRequestFor(http.MethodGet, ""))
+	if statuses := drainStatuses(t, w); len(statuses) != 1 || statuses[0].Node != "node-a" {
+		t.Errorf("draining nodes after undraining node-b = %+v", statuses)
+	}
+
+	for name, body := range map[string]string{
+		"empty":        `{}`,
+		"node and tag": `{"node": "node-a", "tag": "llama"}`,
+		"not JSON":     `node-a`,
+	} {
+		w = httptest.NewRecorder()
+		p.DrainHandler(w, drainRequestFor(http.MethodPost, body))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s drain request = %d, want 400", name, w.Code)
+		}
+	}
+	w = httptest.NewRecorder()
+	p.DrainHandler(w, drainRequestFor(http.MethodPost, `{"tag": "missing"}`))
+	if w.Code != http.StatusNotFound || errorCode(t, w) != CodeUnknownTag {
+		t.Errorf("draining an unknown tag = %d, want 404 unknown_tag", w.Code)
+	}
+}
+
+func TestDrainStatusWaitsForInFlightRequests(t *testing.T) {
+	p := newTestProxy(t, nil)
+	p.TrackRequest(testKey, "node-a", 1)
+	p.draining.add("node-a")
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		w := httptest.NewRecorder()
+		p.DrainHandler(w, httptest.NewRequest(http.MethodGet, "/admin/drain/node-a?wait=5s", nil))
+		done <- w
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("drain status returned while a request was still in flight")
+	case <-time.After(100 * time.Millisecond):
+	}
+	p.TrackRequest(testKey, "node-a", -1)
+
+	select {
+	case w := <-done:
+		var status drainStatus
+		json.NewDecoder(w.Body).Decode(&status)
+		if !status.Drained || status.InFlight != 0 {
+			t.Errorf("drain status = %+v, want drained", status)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("drain status did not return once the node drained")
+	}
+
+	w := httptest.NewRecorder()
+	p.DrainHandler(w, httptest.NewRequest(http.MethodGet, "/admin/drain/node-b", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status of a node that is not draining = %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrorCode is a machine-readable identifier returned in error responses
+type ErrorCode string
+
+const (
+	CodeMissingAPIKey     ErrorCode = "missing_api_key"
+	CodeInvalidAPIKey     ErrorCode = "invalid_api_key"
+	CodeForbidden         ErrorCode = "forbidden"
+	CodeInvalidPath       ErrorCode = "invalid_path"
+	CodeMethodNotAllowed  ErrorCode = "method_not_allowed"
+	CodeInvalidRequest    ErrorCode = "invalid_request"
+	CodeInvalidSignature  ErrorCode = "invalid_signature"
+	CodeUnauthorized      ErrorCode = "unauthorized"
+	CodeUnknownTag        ErrorCode = "unknown_tag"
+	CodeInvalidSelector   ErrorCode = "invalid_tag_selector"
+	CodeInvalidIndex      ErrorCode = "invalid_index"
+	CodeUnknownWorkload   ErrorCode = "unknown_workload"
+	CodeNoHealthyNodes    ErrorCode = "no_healthy_nodes"
+	CodeNodeDraining      ErrorCode = "node_draining"
+	CodeNodesAtCapacity   ErrorCode = "nodes_at_capacity"
+	CodeTooManyKeys       ErrorCode = "too_many_keys"
+	CodeRateLimited       ErrorCode = "rate_limited"
+	CodeRequestTooLarge   ErrorCode = "request_too_large"
+	CodeUpstreamTimeout   ErrorCode = "upstream_timeout"
+	CodeRequestTimeout    ErrorCode = "request_timeout"
+	CodeStreamIdleTimeout ErrorCode = "stream_idle_timeout"
+	CodeUpstreamError     ErrorCode = "upstream_error"
+	CodeWorkloadsAPIError ErrorCode = "workloads_api_error"
+	CodeInternalError     ErrorCode = "internal_error"
+)
+
+// ProxyError is an error with the HTTP status and code reported to clients
+type ProxyError struct {
+	Status    int
+	Code      ErrorCode
+	Message   string
+	Retryable bool
+	Err       error
+}
+
+func (e *ProxyError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+func (e *ProxyError) Unwrap() error { return e.Err }
+
+// wrap records the underlying cause for logging; it is never sent to clients
+func (e *ProxyError) wrap(err error) *ProxyError {
+	e.Err = err
+	return e
+}
+
+func newProxyError(status int, code ErrorCode, format string, v ...interface{}) *ProxyError {
+	return &ProxyError{
+		Status:    status,
+		Code:      code,
+		Message:   fmt.Sprintf(format, v...),
+		Retryable: status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout || status == http.StatusBadGateway,
+	}
+}
+
+// WorkloadsAPIError is returned when the Comput3 workloads API answers with a non-200 status
+type WorkloadsAPIError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *WorkloadsAPIError) Error() string {
+	return fmt.Sprintf("workloads API returned %d: %s", e.StatusCode, e.Body)
+}
+
+// toProxyError maps any error onto the status and code reported to clients
+func toProxyError(err error) *ProxyError {
+	var proxyErr *ProxyError
+	if errors.As(err, &proxyErr) {
+		return proxyErr
+	}
+
+	var apiErr *WorkloadsAPIError
+	if errors.As(err, &apiErr) {
+		switch {
+		case apiErr.StatusCode == http.StatusUnauthorized:
+			return &ProxyError{Status: http.StatusUnauthorized, Code: CodeInvalidAPIKey,
+				Message: "API key was rejected by the Comput3 API", Err: err}
+		case apiErr.StatusCode == http.StatusForbidden:
+			return &ProxyError{Status: http.StatusForbidden, Code: CodeForbidden,
+				Message: "API key is not permitted to list workloads", Err: err}
+		default:
+			return &ProxyError{Status: http.StatusBadGateway, Code: CodeWorkloadsAPIError,
+				Message: "failed to fetch workloads from the Comput3 API", Retryable: true, Err: err}
+		}
+	}
+
+	switch {
+	case errors.Is(err, errBodyTooLarge):
+		return &ProxyError{Status: http.StatusRequestEntityTooLarge, Code: CodeRequestTooLarge,
+			Message: "request body exceeds the configured limit", Err: err}
+	case errors.Is(err, errHeaderTimeout):
+		return &ProxyError{Status: http.StatusGatewayTimeout, Code: CodeUpstreamTimeout,
+			Message: "node did not respond in time", Retryable: true, Err: err}
+	case errors.Is(err, errIdleTimeout):
+		return &ProxyError{Status: http.StatusGatewayTimeout, Code: CodeStreamIdleTimeout,
+			Message: "node stopped sending data", Retryable: true, Err: err}
+	case errors.Is(err, errTotalTimeout):
+		return &ProxyError{Status: http.StatusGatewayTimeout, Code: CodeRequestTimeout,
+			Message: "request deadline exceeded", Retryable: true, Err: err}
+	}
+
+	return &ProxyError{Status: http.StatusInternalServerError, Code: CodeInternalError,
+		Message: "internal proxy error", Err: err}
+}
+
+// errorBody is the JSON error format returned by the proxy
+type errorBody struct {
+	Code      ErrorCode `json:"code"`
+	Message   string    `json:"message"`
+	RequestID string    `json:"request_id,omitempty"`
+	Tag       string    `json:"tag,omitempty"`
+	Retryable bool      `json:"retryable"`
+}
+
+// openAIErrorBody mirrors the OpenAI API error envelope for /v1 clients
+type openAIErrorBody struct {
+	Error struct {
+		Message string    `json:"message"`
+		Type    string    `json:"type"`
+		Param   *string   `json:"param"`
+		Code    ErrorCode `json:"code"`
+	} `json:"error"`
+}
+
+// writeError sends err to the client as a JSON error response
+func (p *ProxyServer) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	proxyErr := toProxyError(err)
+	info := getRequestInfo(r)
+
+	if proxyErr.Status >= 500 {
+		p.logger.Debug("❌ %s (request %s): %v", proxyErr.Code, info.ID, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(proxyErr.Status)
+
+	if p.openAIErrors && isOpenAIPath(r.URL.Path) {
+		var body openAIErrorBody
+		body.Error.Message = proxyErr.Message
+		body.Error.Type = openAIErrorType(proxyErr.Status)
+		body.Error.Code = proxyErr.Code
+		json.NewEncoder(w).Encode(body)
+		return
+	}
+
+	json.NewEncoder(w).Encode(errorBody{
+		Code:      proxyErr.Code,
+		Message:   proxyErr.Message,
+		RequestID: info.ID,
+		Tag:       info.Tag,
+		Retryable: proxyErr.Retryable,
+	})
+}
+
+func isOpenAIPath(path string) bool {
+	return strings.Contains(path, "/v1/") || strings.HasSuffix(path, "/v1")
+}
+
+func openAIErrorType(status int) string {
+	switch {
+	case status == http.StatusUnauthorized:
+		return "authentication_error"
+	case status == http.StatusForbidden:
+		return "permission_error"
+	case status == http.StatusNotFound:
+		return "not_found_error"
+	case status == http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case status < 500:
+		return "invalid_request_error"
+	default:
+		return "server_error"
+	}
+}
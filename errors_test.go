@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestToProxyError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantCode   ErrorCode
+		retryable  bool
+	}{
+		{"proxy error", newProxyError(http.StatusNotFound, CodeUnknownTag, "no tag"), http.StatusNotFound, CodeUnknownTag, false},
+		{"wrapped proxy error", fmt.Errorf("routing: %w", newProxyError(http.StatusServiceUnavailable, CodeNoHealthyNodes, "none")), http.StatusServiceUnavailable, CodeNoHealthyNodes, true},
+		{"key rejected", &WorkloadsAPIError{StatusCode: http.StatusUnauthorized}, http.StatusUnauthorized, CodeInvalidAPIKey, false},
+		{"key forbidden", &WorkloadsAPIError{StatusCode: http.StatusForbidden}, http.StatusForbidden, CodeForbidden, false},
+		{"API failure", &WorkloadsAPIError{StatusCode: http.StatusInternalServerError}, http.StatusBadGateway, CodeWorkloadsAPIError, true},
+		{"body too large", errBodyTooLarge, http.StatusRequestEntityTooLarge, CodeRequestTooLarge, false},
+		{"header timeout", errHeaderTimeout, http.StatusGatewayTimeout, CodeUpstreamTimeout, true},
+		{"idle timeout", fmt.Errorf("read: %w", errIdleTimeout), http.StatusGatewayTimeout, CodeStreamIdleTimeout, true},
+		{"total timeout", errTotalTimeout, http.StatusGatewayTimeout, CodeRequestTimeout, true},
+		{"anything else", errors.New("boom"), http.StatusInternalServerError, CodeInternalError, false},
+	}
+	for _, tt := range tests {
+		got := toProxyError(tt.err)
+		if got.Status != tt.wantStatus || got.Code != tt.wantCode || got.Retryable != tt.retryable {
+			t.Errorf("%s: %d %s (retryable %v), want %d %s (retryable %v)",
+				tt.name, got.Status, got.Code, got.Retryable, tt.wantStatus, tt.wantCode, tt.retryable)
+		}
+	}
+}
+
+func TestWriteErrorFormats(t *testing.T) {
+	p := newTestProxy(t, map[string]string{"OPENAI_ERRORS": "true"})
+	err := newProxyError(http.StatusNotFound, CodeUnknownTag, "no nodes found for tag: llama").wrap(errors.New("internal detail"))
+
+	r := withRequestInfo(httptest.NewRequest(http.MethodGet, "/tags/llama/health", nil), &requestInfo{ID: "req-1", Tag: "llama"})
+	w := httptest.NewRecorder()
+	p.writeError(w, r, err)
+	var body errorBody
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	want := errorBody{Code: CodeUnknownTag, Message: "no nodes found for tag: llama", RequestID: "req-1", Tag: "llama"}
+	if w.Code != http.StatusNotFound || body != want {
+		t.Errorf("error response = %d %+v, want 404 %+v", w.Code, body, want)
+	}
+
+	// /v1 paths get the OpenAI envelope
+	r = withRequestInfo(httptest.NewRequest(http.MethodPost, "/tags/llama/v1/chat/completions", nil), &requestInfo{})
+	w = httptest.NewRecorder()
+	p.writeError(w, r, err)
+	var openAI openAIErrorBody
+	if err := json.NewDecoder(w.Body).Decode(&openAI); err != nil {
+		t.Fatal(err)
+	}
+	if openAI.Error.Type != "not_found_error" || openAI.Error.Code != CodeUnknownTag || openAI.Error.Message != want.Message {
+		t.Errorf("OpenAI error = %+v", openAI.Error)
+	}
+}
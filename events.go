@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// maxWebhookBodyBytes bounds webhook payloads
+const maxWebhookBodyBytes = 4 << 20
+
+// WorkloadEvent notifies the proxy that the workloads of an API key changed.
+// Keys are identified by the hex SHA-256 of the API key. When Workloads is
+// omitted the proxy refetches them from the Comput3 API.
+type WorkloadEvent struct {
+	APIKeyHash string     `json:"api_key_hash"`
+	Workloads  []Workload `json:"workloads,omitempty"`
+}
+
+// findKeyByHash returns the tracked API key with the given hash
+func (p *ProxyServer) findKeyByHash(hash string) (string, bool) {
+	p.cacheLock.RLock()
+	defer p.cacheLock.RUnlock()
+
+	hash = strings.ToLower(hash)
+	for apiKey := range p.workloadCache {
+		if hashKey(apiKey) == hash {
+			return apiKey, true
+		}
+	}
+	return "", false
+}
+
+// applyWorkloadEvent feeds a workload change notification into the cache.
+// Events for keys that are not tracked are ignored.
+func (p *ProxyServer) applyWorkloadEvent(event WorkloadEvent) error {
+	if event.APIKeyHash == "" {
+		return fmt.Errorf("event is missing api_key_hash")
+	}
+
+	apiKey, tracked := p.findKeyByHash(event.APIKeyHash)
+	if !tracked {
+		p.logger.Debug("📭 Ignoring workload event for untracked API key hash %.8s", event.APIKeyHash)
+		return nil
+	}
+
+	if event.Workloads != nil {
+		p.logger.Debug("📬 Applying pushed workloads for API key %s...", maskKey(apiKey))
+		p.updateCache(apiKey, event.Workloads)
+		return nil
+	}
+
+	p.logger.Debug("📬 Workload change notified for API key %s..., refreshing", maskKey(apiKey))
+	workloads, err := p.fetchWorkloads(context.Background(), apiKey)
+	if err != nil {
+		p.recordFetchError(apiKey, err)
+		return err
+	}
+	p.updateCache(apiKey, workloads)
+	return nil
+}
+
+// verifyWebhookSignature checks the X-C3-Signature header ("sha256=<hex>"),
+// an HMAC-SHA256 of the body keyed with WEBHOOK_SECRET
+func verifyWebhookSignature(secret string, body []byte, signature string) bool {
+	signature = strings.TrimPrefix(signature, "sha256=")
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// WebhookHandler accepts workload change notifications via POST. The body is
+// a single WorkloadEvent or an array of them.
+func (p *ProxyServer) WebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		p.writeError(w, r, newProxyError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Webhooks must be sent with POST"))
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBodyBytes))
+	if err != nil {
+		p.writeError(w, r, newProxyError(http.StatusBadRequest, CodeInvalidRequest, "Failed to read webhook body").wrap(err))
+		return
+	}
+
+	if !verifyWebhookSignature(p.webhookSecret, body, r.Header.Get("X-C3-Signature")) {
+		p.logger.Warn("🚫 Rejected workload webhook with invalid signature from %s", r.RemoteAddr)
+		p.writeError(w, r, newProxyError(http.StatusUnauthorized, CodeInvalidSignature, "Invalid webhook signature"))
+		return
+	}
+
+	var events []WorkloadEvent
+	if trimmed := strings.TrimSpace(string(body)); strings.HasPrefix(trimmed, "[") {
+		err = json.Unmarshal(body, &events)
+	} else {
+		var event WorkloadEvent
+		err = json.Unmarshal(body, &event)
+		events = []WorkloadEvent{event}
+	}
+	if err != nil {
+		p.writeError(w, r, newProxyError(http.StatusBadRequest, CodeInvalidRequest, "Invalid webhook payload").wrap(err))
+		return
+	}
+
+	for _, event := range events {
+		if err := p.applyWorkloadEvent(event); err != nil {
+			p.logger.Warn("⚠️  Failed to apply workload event: %v", err)
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// subscribeWorkloadEvents consumes a server-sent event stream of workload
+// events, reconnecting with backoff until shutdown. Polling keeps running
+// alongside it as a fallback.
+func (p *ProxyServer) subscribeWorkloadEvents(url string) {
+	backoff := time.Second
+	for {
+		start := time.Now()
+		err := p.readWorkloadEvents(url)
+
+		select {
+		case <-p.shutdown:
+			return
+		default:
+		}
+
+		if time.Since(start) > time.Minute {
+			backoff = time.Second
+		}
+		p.logger.Warn("⚠️  Workload event stream disconnected: %v, reconnecting in %v", err, backoff)
+
+		select {
+		case <-time.After(backoff):
+		case <-p.shutdown:
+			return
+		}
+		if backoff < time.Minute {
+			backoff *= 2
+		}
+	}
+}
+
+// readWorkloadEvents reads events from a single SSE connection until it ends
+func (p *ProxyServer) readWorkloadEvents(url string) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if token := os.Getenv("WORKLOAD_EVENTS_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("event stream returned %d", resp.StatusCode)
+	}
+	p.logger.Info("📡 Subscribed to workload events at %s", url)
+
+	// Unblock the reader on shutdown
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-p.shutdown:
+			resp.Body.Close()
+		case <-done:
+		}
+	}()
+
+	var data strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), maxWebhookBodyBytes)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			// A blank line dispatches the buffered event
+			if data.Len() > 0 {
+				var event WorkloadEvent
+				if err := json.Unmarshal([]byte(data.String()), &event); err != nil {
+					p.logger.Warn("⚠️  Ignoring malformed workload event: %v", err)
+				} else if err := p.applyWorkloadEvent(event); err != nil {
+					p.logger.Warn("⚠️  Failed to apply workload event: %v", err)
+				}
+				data.Reset()
+			}
+		case strings.HasPrefix(line, "data:"):
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
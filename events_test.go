@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testWebhookSecret = "webhook-secret"
+
+func sign(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	body := `{"api_key_hash": "abc"}`
+	tests := map[string]bool{
+		sign(testWebhookSecret, body):                                true,
+		strings.TrimPrefix(sign(testWebhookSecret, body), "sha256="): true,
+		sign("other-secret", body):                                   false,
+		sign(testWebhookSecret, body+" "):                            false,
+		"sha256=not-hex":                                             false,
+		"":                                                           false,
+	}
+	for signature, valid := range tests {
+		if got := verifyWebhookSignature(testWebhookSecret, []byte(body), signature); got != valid {
+			t.Errorf("verifyWebhookSignature(%q) = %v, want %v", signature, got, valid)
+		}
+	}
+}
+
+func webhookRequest(body, signature string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/webhooks/workloads", strings.NewReader(body))
+	if signature != "" {
+		r.Header.Set("X-C3-Signature", signature)
+	}
+	return r
+}
+
+func TestWebhookAppliesSignedEvents(t *testing.T) {
+	p := newTestProxy(t, map[string]string{"WEBHOOK_SECRET": testWebhookSecret})
+	seedWorkloads(p, testKey, testWorkload("node-a", "w-1", "llama"))
+	body := fmt.Sprintf(`[{"api_key_hash": %q, "workloads": [{"node": "node-b", "workload": "w-2", "status": "running"}]}]`,
+		strings.ToUpper(hashKey(testKey)))
+
+	w := httptest.NewRecorder()
+	p.WebhookHandler(w, webhookRequest(body, sign(testWebhookSecret, body)))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("signed webhook = %d, want 204", w.Code)
+	}
+	p.cacheLock.RLock()
+	workloads := p.workloadCache[testKey].Workloads
+	p.cacheLock.RUnlock()
+	if len(workloads) != 1 || workloads[0].Node != "node-b" {
+		t.Errorf("cached workloads after the webhook = %+v, want the pushed node-b", workloads)
+	}
+}
+
+func TestWebhookRejectsBadSignatures(t *testing.T) {
+	p := newTestProxy(t, map[string]string{"WEBHOOK_SECRET": testWebhookSecret})
+	seedWorkloads(p, testKey, testWorkload("node-a", "w-1", "llama"))
+	body := fmt.Sprintf(`{"api_key_hash": %q, "workloads": []}`, hashKey(testKey))
+
+	for name, signature := range map[string]string{
+		"unsigned":     "",
+		"wrong secret": sign("other-secret", body),
+		"other body":   sign(testWebhookSecret, body+" "),
+	} {
+		w := httptest.NewRecorder()
+		p.WebhookHandler(w, webhookRequest(body, signature))
+		if w.Code != http.StatusUnauthorized || errorCode(t, w) != CodeInvalidSignature {
+			t.Errorf("%s webhook = %d, want 401 invalid_signature", name, w.Code)
+		}
+	}
+	p.cacheLock.RLock()
+	count := len(p.workloadCache[testKey].Workloads)
+	p.cacheLock.RUnlock()
+	if count != 1 {
+		t.Errorf("%d cached workloads, want the rejected webhooks to leave the cache alone", count)
+	}
+
+	w := httptest.NewRecorder()
+	p.WebhookHandler(w, httptest.NewRequest(http.MethodGet, "/webhooks/workloads", nil))
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != http.MethodPost {
+		t.Errorf("GET webhook = %d, want 405 allowing POST", w.Code)
+	}
+}
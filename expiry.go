@@ -0,0 +1,162 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// ExpiryPolicy controls how workloads close to their expiry time are routed.
+// Workloads expiring within Exclude receive no new requests, those within
+// Deprioritize are only used when no longer-lived node is available, and a
+// warning is logged once a workload is within Warning of expiring.
+type ExpiryPolicy struct {
+	Exclude      time.Duration
+	Deprioritize time.Duration
+	Warning      time.Duration
+}
+
+func loadExpiryPolicy() ExpiryPolicy {
+	return ExpiryPolicy{
+		Exclude:      getEnvDuration("EXPIRY_EXCLUDE_WINDOW", 2*time.Minute),
+		Deprioritize: getEnvDuration("EXPIRY_DEPRIORITIZE_WINDOW", 10*time.Minute),
+		Warning:      getEnvDuration("EXPIRY_WARNING_WINDOW", 15*time.Minute),
+	}
+}
+
+// workloadExpiry returns when a workload expires. Expires is a Unix timestamp
+// in seconds (milliseconds are accepted too); zero means no expiry.
+func workloadExpiry(w Workload) (time.Time, bool) {
+	if w.Expires <= 0 {
+		return time.Time{}, false
+	}
+	if w.Expires > 1e12 {
+		return time.UnixMilli(w.Expires), true
+	}
+	return time.Unix(w.Expires, 0), true
+}
+
+// timeToExpiry returns how long until a workload expires, or false if it has no expiry
+func timeToExpiry(w Workload, now time.Time) (time.Duration, bool) {
+	expires, ok := workloadExpiry(w)
+	if !ok {
+		return 0, false
+	}
+	return expires.Sub(now), true
+}
+
+// workloadsByNode indexes a key's running workloads by node; callers must hold cacheLock
+func (p *ProxyServer) workloadsByNode(apiKey string) map[string]Workload {
+	byNode := make(map[string]Workload)
+	if cache, exists := p.workloadCache[apiKey]; exists {
+		for _, w := range cache.Workloads {
+			if w.Running && w.Status == "running" {
+				byNode[w.Node] = w
+			}
+		}
+	}
+	return byNode
+}
+
+// isExpiring reports whether a node's workload is inside the exclusion window;
+// callers must hold cacheLock
+func (p *ProxyServer) isExpiring(apiKey, node string) bool {
+	w, exists := p.workloadsByNode(apiKey)[node]
+	if !exists {
+		return false
+	}
+	remaining, ok := timeToExpiry(w, time.Now())
+	return ok && remaining <= p.expiry.Exclude
+}
+
+// preferLongLived drops nodes about to expire and, when possible, nodes
+// within the deprioritization window; callers must hold cacheLock
+func (p *ProxyServer) preferLongLived(apiKey string, nodes []string) ([]string, error) {
+	byNode := p.workloadsByNode(apiKey)
+	now := time.Now()
+
+	var preferred, expiringSoon []string
+	for _, node := range nodes {
+		remaining, ok := timeToExpiry(byNode[node], now)
+		switch {
+		case !ok || remaining > p.expiry.Deprioritize:
+			preferred = append(preferred, node)
+		case remaining > p.expiry.Exclude:
+			expiringSoon = append(expiringSoon, node)
+		default:
+			p.logger.Debug("⌛ Skipping node %s, workload expires in %v", node, remaining.Round(time.Second))
+		}
+	}
+
+	if len(preferred) > 0 {
+		return preferred, nil
+	}
+	if len(expiringSoon) > 0 {
+		p.logger.Debug("⌛ Only nodes close to expiry available: %v", expiringSoon)
+		return expiringSoon, nil
+	}
+	return nil, newProxyError(http.StatusServiceUnavailable, CodeNoHealthyNodes, "all matching nodes are about to expire")
+}
+
+// warnExpiring logs a warning once per workload as it enters the warning
+// window; callers must hold cacheLock
+func (p *ProxyServer) warnExpiring(apiKey string, cache *WorkloadCache, workloads []Workload) {
+	if p.expiry.Warning <= 0 {
+		return
+	}
+	if cache.ExpiryWarned == nil {
+		cache.ExpiryWarned = make(map[string]bool)
+	}
+
+	now := time.Now()
+	current := make(map[string]bool)
+	for _, w := range workloads {
+		id := w.Workload + "@" + w.Node
+		current[id] = true
+		remaining, ok := timeToExpiry(w, now)
+		if !ok || !w.Running || remaining > p.expiry.Warning || cache.ExpiryWarned[id] {
+			continue
+		}
+		cache.ExpiryWarned[id] = true
+		p.logger.Warn("⌛ Workload %s on node %s for API key %s... expires in %v",
+			w.Workload, w.Node, maskKey(apiKey), remaining.Round(time.Second))
+	}
+
+	for id := range cache.ExpiryWarned {
+		if !current[id] {
+			delete(cache.ExpiryWarned, id)
+		}
+	}
+}
+
+// WorkloadView is a workload annotated with expiry information for API responses
+type WorkloadView struct {
+	Workload
+	ExpiresAt string `json:"expires_at,omitempty"`
+	ExpiresIn int64  `json:"expires_in,omitempty"` // seconds
+	Expiring  bool   `json:"expiring"`             // excluded from or deprioritized in routing
+	Index     *int   `json:"index,omitempty"`      // position for /{index} routes
+}
+
+// workloadViews annotates workloads with expiry information
+func (p *ProxyServer) workloadViews(workloads []Workload) []WorkloadView {
+	now := time.Now()
+	indexes := make(map[string]int)
+	for i, w := range p.runningWorkloads(workloads) {
+		indexes[w.Workload+"@"+w.Node] = i
+	}
+
+	views := make([]WorkloadView, 0, len(workloads))
+	for _, w := range workloads {
+		view := WorkloadView{Workload: w}
+		if index, ok := indexes[w.Workload+"@"+w.Node]; ok {
+			view.Index = &index
+		}
+		if expires, ok := workloadExpiry(w); ok {
+			view.ExpiresAt = expires.UTC().Format(time.RFC3339)
+			view.ExpiresIn = int64(expires.Sub(now).Seconds())
+			view.Expiring = expires.Sub(now) <= p.expiry.Deprioritize
+		}
+		views = append(views, view)
+	}
+	return views
+}
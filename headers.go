@@ -0,0 +1,279 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Hop-by-hop headers as defined by RFC 7230 section 6.1. These apply to a
+// single transport-level connection and must not be forwarded by proxies.
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection", // non-standard but still sent by some clients
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// HeaderConfig controls how headers are rewritten between clients and nodes
+type HeaderConfig struct {
+	// HostMode selects the Host sent to nodes: "node" (default) uses the node
+	// hostname, "client" preserves the incoming Host, anything else is used literally
+	HostMode string
+	// SNI overrides the TLS server name sent to nodes; empty uses the node hostname
+	SNI string
+	// ForwardedHeaders enables X-Forwarded-For/Proto/Host and Forwarded injection
+	ForwardedHeaders bool
+	// TrustedProxies lists networks whose existing forwarding headers are preserved
+	TrustedProxies []*net.IPNet
+	// APIKeyMode selects what happens to the client's API key: "pass" (default),
+	// "strip" or "replace"
+	APIKeyMode string
+	// APIKeyReplacement is sent to nodes in place of the client's key in "replace" mode
+	APIKeyReplacement string
+}
+
+func loadHeaderConfig(logger *Logger) HeaderConfig {
+	cfg := HeaderConfig{
+		HostMode:          os.Getenv("UPSTREAM_HOST"),
+		SNI:               os.Getenv("UPSTREAM_SNI"),
+		ForwardedHeaders:  getEnvBool("FORWARDED_HEADERS", true),
+		APIKeyMode:        strings.ToLower(os.Getenv("UPSTREAM_API_KEY_MODE")),
+		APIKeyReplacement: os.Getenv("UPSTREAM_API_KEY"),
+	}
+	if cfg.HostMode == "" {
+		cfg.HostMode = "node"
+	}
+
+	switch cfg.APIKeyMode {
+	case "", "pass":
+		cfg.APIKeyMode = "pass"
+	case "strip":
+	case "replace":
+		if cfg.APIKeyReplacement == "" {
+			logger.Warn("⚠️  UPSTREAM_API_KEY_MODE=replace without UPSTREAM_API_KEY, stripping API key instead")
+			cfg.APIKeyMode = "strip"
+		}
+	default:
+		logger.Warn("⚠️  Invalid UPSTREAM_API_KEY_MODE %s, passing API key through", cfg.APIKeyMode)
+		cfg.APIKeyMode = "pass"
+	}
+
+	for _, entry := range getEnvList("TRUSTED_PROXIES") {
+		if !strings.Contains(entry, "/") {
+			if strings.Contains(entry, ":") {
+				entry += "/128"
+			} else {
+				entry += "/32"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			logger.Warn("⚠️  Ignoring invalid trusted proxy %s: %v", entry, err)
+			continue
+		}
+		cfg.TrustedProxies = append(cfg.TrustedProxies, network)
+	}
+
+	return cfg
+}
+
+// isTrustedProxy reports whether the given IP belongs to a trusted proxy network
+func (c HeaderConfig) isTrustedProxy(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range c.TrustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func copyHeader(dst, src http.Header) {
+	for k, vv := range src {
+		for _, v := range vv {
+			dst.Add(k, v)
+		}
+	}
+}
+
+// removeHopHeaders deletes hop-by-hop headers, including any listed in the
+// Connection header, as required by RFC 7230 section 6.1
+func removeHopHeaders(h http.Header) {
+	for _, value := range h["Connection"] {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
+
+// headerHasToken reports whether a comma-separated header lists token
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, value := range h[name] {
+		for _, item := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(item), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// upgradeType returns the protocol a request or response switches to, or ""
+// if its Connection header does not ask for an upgrade
+func upgradeType(h http.Header) string {
+	if !headerHasToken(h, "Connection", "upgrade") {
+		return ""
+	}
+	return h.Get("Upgrade")
+}
+
+// acceptsTrailers reports whether the client sent "TE: trailers"
+func acceptsTrailers(h http.Header) bool {
+	return headerHasToken(h, "Te", "trailers")
+}
+
+// prepareUpstreamRequest rewrites the outgoing request headers for a node:
+// hop-by-hop headers are dropped, Host is set, forwarding headers are added
+// and the client's API key is handled according to the configuration.
+// Like httputil.ReverseProxy, it keeps a protocol upgrade and "TE: trailers",
+// which are meant for the node rather than the connection to the proxy.
+func (p *ProxyServer) prepareUpstreamRequest(proxyReq, r *http.Request, node, apiKey string) {
+	upgrade := upgradeType(proxyReq.Header)
+	trailers := acceptsTrailers(proxyReq.Header)
+	removeHopHeaders(proxyReq.Header)
+	if trailers {
+		proxyReq.Header.Set("Te", "trailers")
+	}
+	if upgrade != "" {
+		proxyReq.Header.Set("Connection", "Upgrade")
+		proxyReq.Header.Set("Upgrade", upgrade)
+	}
+
+	switch p.headers.HostMode {
+	case "node":
+		proxyReq.Host = node
+	case "client":
+		proxyReq.Host = r.Host
+	default:
+		proxyReq.Host = p.headers.HostMode
+	}
+
+	if p.headers.ForwardedHeaders {
+		p.setForwardedHeaders(proxyReq.Header, r)
+	}
+
+	switch p.headers.APIKeyMode {
+	case "strip":
+		stripAPIKey(proxyReq.Header, apiKey, "")
+	case "replace":
+		stripAPIKey(proxyReq.Header, apiKey, p.headers.APIKeyReplacement)
+	}
+}
+
+// stripAPIKey removes the client's API key from the headers it may have been
+// sent in. If replacement is non-empty it is written in its place instead.
+func stripAPIKey(h http.Header, apiKey, replacement string) {
+	if h.Get("X-C3-API-KEY") != "" {
+		if replacement != "" {
+			h.Set("X-C3-API-KEY", replacement)
+		} else {
+			h.Del("X-C3-API-KEY")
+		}
+	}
+	if h.Get("Authorization") == "Bearer "+apiKey {
+		if replacement != "" {
+			h.Set("Authorization", "Bearer "+replacement)
+		} else {
+			h.Del("Authorization")
+		}
+	}
+}
+
+// setForwardedHeaders injects X-Forwarded-For/Proto/Host and Forwarded (RFC 7239).
+// Values supplied by the client are only kept when it is a trusted proxy.
+func (p *ProxyServer) setForwardedHeaders(h http.Header, r *http.Request) {
+	clientIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		clientIP = r.RemoteAddr
+	}
+	trusted := p.headers.isTrustedProxy(net.ParseIP(clientIP))
+
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+
+	if !trusted {
+		h.Del("X-Forwarded-For")
+		h.Del("X-Forwarded-Proto")
+		h.Del("X-Forwarded-Host")
+		h.Del("Forwarded")
+	}
+
+	if prior := h.Get("X-Forwarded-For"); prior != "" {
+		h.Set("X-Forwarded-For", prior+", "+clientIP)
+	} else {
+		h.Set("X-Forwarded-For", clientIP)
+	}
+	if h.Get("X-Forwarded-Proto") == "" {
+		h.Set("X-Forwarded-Proto", proto)
+	}
+	if h.Get("X-Forwarded-Host") == "" {
+		h.Set("X-Forwarded-Host", r.Host)
+	}
+
+	forwarded := "for=" + forwardedNode(clientIP) + ";proto=" + proto
+	if r.Host != "" {
+		forwarded += ";host=" + quoteForwarded(r.Host)
+	}
+	if prior := strings.Join(h.Values("Forwarded"), ", "); prior != "" {
+		forwarded = prior + ", " + forwarded
+	}
+	h.Set("Forwarded", forwarded)
+}
+
+// forwardedNode formats an IP for the Forwarded header; IPv6 addresses must
+// be bracketed and quoted
+func forwardedNode(ip string) string {
+	if strings.Contains(ip, ":") {
+		return "\"[" + ip + "]\""
+	}
+	return ip
+}
+
+// quoteForwarded quotes a Forwarded parameter value if it contains characters
+// outside the RFC 7230 token set
+func quoteForwarded(value string) string {
+	for _, c := range value {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+			strings.ContainsRune("!#$%&'*+-.^_`|~", c)) {
+			return "\"" + strings.ReplaceAll(value, "\"", "\\\"") + "\""
+		}
+	}
+	return value
+}
+
+// newUpstreamClient builds the HTTP client used to reach nodes. SNI follows
+// the node hostname unless explicitly overridden, independent of the Host header.
+func newUpstreamClient(cfg HeaderConfig) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.SNI != "" {
+		transport.TLSClientConfig = &tls.Config{ServerName: cfg.SNI}
+	}
+	return &http.Client{Transport: transport}
+}
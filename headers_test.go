@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// headerEcho routes tag llama to a node that reports the headers it received
+func headerEcho(t *testing.T, env map[string]string) (*ProxyServer, *http.Header) {
+	t.Helper()
+	received := new(http.Header)
+	node := newTestNode(t, func(w http.ResponseWriter, r *http.Request) {
+		*received = r.Header.Clone()
+	})
+	p := newTestProxy(t, env)
+	seedWorkloads(p, testKey, testWorkload(node, "w-1", "llama"))
+	return p, received
+}
+
+func TestHopHeadersAreRemoved(t *testing.T) {
+	p, received := headerEcho(t, nil)
+
+	r := httptest.NewRequest(http.MethodGet, "/tags/llama/v1/models", nil)
+	r.Header.Set("Connection", "keep-alive, X-Session-Hint")
+	r.Header.Set("X-Session-Hint", "1")
+	r.Header.Set("Keep-Alive", "timeout=5")
+	r.Header.Set("Proxy-Authorization", "Basic Zm9vOmJhcg==")
+	r.Header.Set("Te", "gzip")
+	r.Header.Set("X-Custom", "kept")
+	doRequest(p, r)
+
+	for _, name := range []string{"Connection", "X-Session-Hint", "Keep-Alive", "Proxy-Authorization", "Te", "Upgrade"} {
+		if value := received.Get(name); value != "" {
+			t.Errorf("node received hop-by-hop header %s: %s", name, value)
+		}
+	}
+	if received.Get("X-Custom") != "kept" {
+		t.Error("node did not receive an end-to-end header")
+	}
+}
+
+func TestUpgradeAndTrailersArePassed(t *testing.T) {
+	p, received := headerEcho(t, nil)
+
+	r := httptest.NewRequest(http.MethodGet, "/tags/llama/v1/models", nil)
+	r.Header.Set("Te", "trailers, deflate")
+	doRequest(p, r)
+	if got := received.Get("Te"); got != "trailers" {
+		t.Errorf("Te = %q, want trailers", got)
+	}
+
+	// Upgrade is kept only when Connection asks for it
+	r = httptest.NewRequest(http.MethodGet, "/tags/llama/v1/models", nil)
+	r.Header.Set("Upgrade", "websocket")
+	doRequest(p, r)
+	if got := received.Get("Upgrade"); got != "" {
+		t.Errorf("Upgrade without Connection: upgrade was forwarded as %q", got)
+	}
+	if got := upgradeType(http.Header{"Connection": {"keep-alive, Upgrade"}, "Upgrade": {"websocket"}}); got != "websocket" {
+		t.Errorf("upgradeType = %q, want websocket", got)
+	}
+}
+
+func TestUpgradeIsTunnelled(t *testing.T) {
+	node := newTestNode(t, func(w http.ResponseWriter, r *http.Request) {
+		if upgradeType(r.Header) != "echo" {
+			http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+		conn, buffered, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		buffered.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		buffered.Flush()
+		io.Copy(conn, buffered)
+	})
+	p := newTestProxy(t, nil)
+	seedWorkloads(p, testKey, testWorkload(node, "w-1", "llama"))
+	proxy := httptest.NewServer(http.HandlerFunc(p.ProxyHandler))
+	defer proxy.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(proxy.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET /tags/llama/echo HTTP/1.1\r\nHost: proxy\r\nConnection: Upgrade\r\nUpgrade: echo\r\nX-C3-API-KEY: "+testKey+"\r\n\r\n")
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Upgrade") != "echo" {
+		t.Fatalf("upgrade response = %d with Upgrade %q", resp.StatusCode, resp.Header.Get("Upgrade"))
+	}
+
+	io.WriteString(conn, "ping\n")
+	if line, err := reader.ReadString('\n'); err != nil || line != "ping\n" {
+		t.Errorf("echo through the tunnel = %q, %v", line, err)
+	}
+}
+
+func TestUpstreamAPIKeyModes(t *testing.T) {
+	tests := []struct {
+		mode, replacement string
+		wantKey, wantAuth string
+		viaBearer         bool
+	}{
+		{mode: "pass", wantKey: testKey},
+		{mode: "pass", viaBearer: true, wantAuth: "Bearer " + testKey},
+		{mode: "strip"},
+		{mode: "strip", viaBearer: true},
+		{mode: "replace", replacement: "node-key", wantKey: "node-key"},
+		{mode: "replace", replacement: "node-key", viaBearer: true, wantAuth: "Bearer node-key"},
+	}
+	for _, tt := range tests {
+		p, received := headerEcho(t, map[string]string{"UPSTREAM_API_KEY_MODE": tt.mode, "UPSTREAM_API_KEY": tt.replacement})
+
+		r := httptest.NewRequest(http.MethodGet, "/tags/llama/v1/models", nil)
+		if tt.viaBearer {
+			r.Header.Set("Authorization", "Bearer "+testKey)
+		}
+		doRequest(p, r)
+		if got := received.Get("X-C3-API-KEY"); got != tt.wantKey {
+			t.Errorf("%s (bearer %v): X-C3-API-KEY = %q, want %q", tt.mode, tt.viaBearer, got, tt.wantKey)
+		}
+		if got := received.Get("Authorization"); got != tt.wantAuth {
+			t.Errorf("%s (bearer %v): Authorization = %q, want %q", tt.mode, tt.viaBearer, got, tt.wantAuth)
+		}
+	}
+}
+
+func TestForwardedHeaders(t *testing.T) {
+	p, received := headerEcho(t, map[string]string{"TRUSTED_PROXIES": "10.0.0.1"})
+
+	for _, tt := range []struct{ remote, want string }{
+		{"192.0.2.7:1234", "192.0.2.7"},
+		{"10.0.0.1:1234", "198.51.100.1, 10.0.0.1"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/tags/llama/v1/models", nil)
+		r.RemoteAddr = tt.remote
+		r.Header.Set("X-Forwarded-For", "198.51.100.1")
+		doRequest(p, r)
+		if got := received.Get("X-Forwarded-For"); got != tt.want {
+			t.Errorf("from %s: X-Forwarded-For = %q, want %q", tt.remote, got, tt.want)
+		}
+	}
+}
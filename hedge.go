@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	hedgeSamples    = 200 // recent response times kept per tag
+	hedgeMinSamples = 20  // below this the hedge delay is MaxDelay
+	hedgeBurst      = 10  // most hedges that can be saved up from the budget
+)
+
+// HedgePolicy enables request hedging for a tag: when the first node has not
+// returned response headers within the Percentile of recent response times,
+// the request is also sent to a second node and the first response wins.
+type HedgePolicy struct {
+	Percentile   float64  `json:"percentile,omitempty"`
+	MinDelay     Duration `json:"min_delay,omitempty"`
+	MaxDelay     Duration `json:"max_delay,omitempty"`
+	Budget       float64  `json:"budget,omitempty"`         // hedges per request
+	MaxBodyBytes int64    `json:"max_body_bytes,omitempty"` // larger bodies are not buffered for hedging
+}
+
+// HedgeConfig holds hedge policies by tag; tags without one are never hedged
+type HedgeConfig struct {
+	Tags map[string]HedgePolicy `json:"tags"`
+}
+
+// Hedger tracks response times and hedge budgets for tags with a hedge policy
+type Hedger struct {
+	tags map[string]*hedgeTracker
+	sent *Counter
+	won  *Counter
+}
+
+// loadHedger reads HEDGING (or HEDGING_FILE)
+func loadHedger(logger *Logger, metrics *Metrics) (*Hedger, error) {
+	var cfg HedgeConfig
+	found, err := loadJSONEnv("HEDGING", &cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &Hedger{
+		tags: make(map[string]*hedgeTracker, len(cfg.Tags)),
+		sent: metrics.NewCounter("c3_proxy_hedged_requests_total", "Hedge requests sent to a second node"),
+		won:  metrics.NewCounter("c3_proxy_hedge_wins_total", "Hedge requests that answered before the original request"),
+	}
+	for tag, policy := range cfg.Tags {
+		if policy.Percentile == 0 {
+			policy.Percentile = 95
+		}
+		if policy.MaxDelay == 0 {
+			policy.MaxDelay = Duration(time.Second)
+		}
+		if policy.Budget == 0 {
+			policy.Budget = 0.1
+		}
+		if policy.MaxBodyBytes == 0 {
+			policy.MaxBodyBytes = 1 << 20
+		}
+		if policy.Percentile <= 0 || policy.Percentile >= 100 {
+			return nil, fmt.Errorf("hedge percentile for tag %s must be between 0 and 100", tag)
+		}
+		if policy.MinDelay > policy.MaxDelay {
+			return nil, fmt.Errorf("hedge min_delay for tag %s is above max_delay", tag)
+		}
+		h.tags[tag] = &hedgeTracker{policy: policy, tokens: 1}
+	}
+
+	if found {
+		logger.Info("🦔 Loaded hedge policies for %d tags", len(h.tags))
+	}
+	return h, nil
+}
+
+// forTag returns the tracker for a tag, or nil if the tag is not hedged
+func (h *Hedger) forTag(tag string) *hedgeTracker {
+	if h == nil || tag == "" {
+		return nil
+	}
+	return h.tags[tag]
+}
+
+type hedgeTracker struct {
+	policy  HedgePolicy
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+	tokens  float64
+}
+
+// canReplay reports whether a request's body is small enough to buffer so it
+// can be sent to a second node
+func (t *hedgeTracker) canReplay(r *http.Request) bool {
+	return t != nil && r.ContentLength >= 0 && r.ContentLength <= t.policy.MaxBodyBytes
+}
+
+// record adds the time a node took to return response headers
+func (t *hedgeTracker) record(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.samples) < hedgeSamples {
+		t.samples = append(t.samples, d)
+		return
+	}
+	t.samples[t.next] = d
+	t.next = (t.next + 1) % hedgeSamples
+}
+
+// delay returns how long to wait for the first node before hedging
+func (t *hedgeTracker) delay() time.Duration {
+	t.mu.Lock()
+	samples := append([]time.Duration{}, t.samples...)
+	t.mu.Unlock()
+
+	maxDelay := time.Duration(t.policy.MaxDelay)
+	if len(samples) < hedgeMinSamples {
+		return maxDelay
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	delay := samples[int(float64(len(samples)-1)*t.policy.Percentile/100)]
+	if delay < time.Duration(t.policy.MinDelay) {
+		delay = time.Duration(t.policy.MinDelay)
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	return delay
+}
+
+// deposit adds a request's share of the hedge budget
+func (t *hedgeTracker) deposit() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tokens += t.policy.Budget
+	if t.tokens > hedgeBurst {
+		t.tokens = hedgeBurst
+	}
+}
+
+// allow spends one hedge from the budget if there is one left
+func (t *hedgeTracker) allow() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tokens < 1 {
+		return false
+	}
+	t.tokens--
+	return true
+}
+
+// upstreamAttempt is a request sent to a node
+type upstreamAttempt struct {
+	node    string
+	sent    time.Time
+	resp    *http.Response
+	err     error
+	release func() // ends in-flight tracking for the node
+	cancel  context.CancelFunc
+	lost    []lostAttempt // other attempts this one answered before
+}
+
+// lostAttempt is a hedged attempt that did not produce the response
+type lostAttempt struct {
+	node    string
+	elapsed time.Duration
+	failed  bool // failed rather than being cancelled
+}
+
+// sendUpstream sends proxyReq to node. With a hedge tracker and the buffered
+// request body, a copy may also be sent to a second node.
+func (p *ProxyServer) sendUpstream(r, proxyReq *http.Request, apiKey, node string, hedge *hedgeTracker, replay []byte) *upstreamAttempt {
+	info := getRequestInfo(r)
+	if hedge == nil {
+		attempt := &upstreamAttempt{node: node, release: p.trackInFlight(apiKey, node), sent: time.Now()}
+		info.Attempts++
+		attempt.resp, attempt.err = p.upstream.Do(proxyReq)
+		return attempt
+	}
+	hedge.deposit()
+
+	results := make(chan *upstreamAttempt, 2)
+	var attempts []*upstreamAttempt
+	launch := func(node string, req *http.Request, span *Span) {
+		ctx, cancel := context.WithCancel(req.Context())
+		untrack := p.trackInFlight(apiKey, node)
+		attempt := &upstreamAttempt{node: node, sent: time.Now(), cancel: cancel, release: func() {
+			cancel()
+			untrack()
+		}}
+		attempts = append(attempts, attempt)
+		info.Attempts++
+		go func() {
+			attempt.resp, attempt.err = p.upstream.Do(req.WithContext(ctx))
+			if span != nil {
+				span.RecordError(attempt.err)
+				if attempt.resp != nil {
+					span.SetAttr("http.response.status_code", attempt.resp.StatusCode)
+				}
+				span.End()
+			}
+			results <- attempt
+		}()
+	}
+	launch(node, proxyReq, nil)
+
+	timer := time.NewTimer(hedge.delay())
+	defer timer.Stop()
+
+	pending := 1
+	var failed *upstreamAttempt
+	for pending > 0 {
+		select {
+		case attempt := <-results:
+			pending--
+			if attempt.err != nil {
+				// The first node took at least this long, even though it failed
+				if attempt.node == node {
+					hedge.record(time.Since(attempt.sent))
+				}
+				attempt.release()
+				failed = attempt
+				continue
+			}
+			hedge.record(time.Since(attempt.sent))
+			if attempt.node != node {
+				p.hedging.won.Inc()
+				p.logger.Debug("🦔 Hedge request to node %s answered before node %s", attempt.node, node)
+			}
+
+			// Cancel the losing request and release it once it has returned.
+			// A cancelled first node is recorded as a lower bound on its
+			// response time, otherwise only the faster answers would be
+			// sampled and the hedge delay would keep shrinking.
+			for _, other := range attempts {
+				if other != attempt {
+					other.cancel()
+					attempt.lost = append(attempt.lost, lostAttempt{node: other.node, elapsed: time.Since(other.sent), failed: other == failed})
+				}
+			}
+			go func(remaining int) {
+				for ; remaining > 0; remaining-- {
+					loser := <-results
+					if loser.err == nil || loser.node == node {
+						hedge.record(time.Since(loser.sent))
+					}
+					if loser.resp != nil {
+						loser.resp.Body.Close()
+					}
+					loser.release()
+				}
+			}(pending)
+			return attempt
+		case <-timer.C:
+			if len(attempts) > 1 {
+				continue
+			}
+			second, err := p.getLeastBusyNode(r.Context(), apiKey, info.Tag, node)
+			if err != nil {
+				p.logger.Debug("🦔 No second node to hedge node %s: %v", node, err)
+				continue
+			}
+			if !hedge.allow() {
+				p.logger.Debug("🦔 Hedge budget for tag %s is spent, waiting for node %s", info.Tag, node)
+				continue
+			}
+			req, err := p.newUpstreamRequest(proxyReq.Context(), r, second, apiKey, bytes.NewReader(replay))
+			if err != nil {
+				continue
+			}
+			_, span := p.tracer.Start(r.Context(), "proxy.hedge", spanKindClient)
+			span.SetAttr("server.address", second)
+			span.SetAttr("http.request.method", r.Method)
+			span.inject(req.Header)
+
+			p.logger.Debug("🦔 Node %s has not answered, hedging request to node %s", node, second)
+			p.hedging.sent.Inc()
+			launch(second, req, span)
+			pending++
+		}
+	}
+	return failed
+}
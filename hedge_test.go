@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// hedgedPair starts a slow and a fast node for tag bge, hedged after 50ms
+func hedgedPair(t *testing.T) (p *ProxyServer, slowNode, fastNode string) {
+	t.Helper()
+	slowNode = newTestNode(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+	})
+	fastNode = newTestNode(t, func(w http.ResponseWriter, r *http.Request) {})
+
+	p = newTestProxy(t, map[string]string{"HEDGING": `{"tags": {"bge": {"max_delay": "50ms"}}}`})
+	seedWorkloads(p, testKey, testWorkload(slowNode, "w-slow", "bge"), testWorkload(fastNode, "w-fast", "bge"))
+	return p, slowNode, fastNode
+}
+
+func TestHedgeRecordsCancelledFirstNode(t *testing.T) {
+	p, slowNode, fastNode := hedgedPair(t)
+
+	r := withRequestInfo(httptest.NewRequest(http.MethodPost, "/v1/embeddings", nil), &requestInfo{Tag: "bge", Start: time.Now()})
+	proxyReq, err := p.newUpstreamRequest(r.Context(), r, slowNode, testKey, http.NoBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hedge := p.hedging.forTag("bge")
+	attempt := p.sendUpstream(r, proxyReq, testKey, slowNode, hedge, nil)
+	if attempt.err != nil {
+		t.Fatalf("hedged request failed: %v", attempt.err)
+	}
+	attempt.resp.Body.Close()
+	attempt.release()
+	if attempt.node != fastNode {
+		t.Fatalf("answer came from %s, want the hedge to %s", attempt.node, fastNode)
+	}
+
+	waitFor(t, "the cancelled first node to be recorded", func() bool {
+		hedge.mu.Lock()
+		defer hedge.mu.Unlock()
+		return len(hedge.samples) == 2
+	})
+	hedge.mu.Lock()
+	defer hedge.mu.Unlock()
+	if slowest := max(hedge.samples[0], hedge.samples[1]); slowest < 50*time.Millisecond {
+		t.Errorf("slowest sample %v, want the first node's time of at least the hedge delay", slowest)
+	}
+}
+
+// spanRecorder keeps exported spans for inspection
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+func (e *spanRecorder) Export(s *Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, s)
+}
+
+func (e *spanRecorder) Shutdown() {}
+
+func (e *spanRecorder) find(name string) *Span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, s := range e.spans {
+		if s.name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func TestHedgeSpansDescribeWinner(t *testing.T) {
+	p, slowNode, fastNode := hedgedPair(t)
+	exporter := &spanRecorder{}
+	p.tracer = &Tracer{exporter: exporter, ratio: 1, enabled: true}
+
+	r := httptest.NewRequest(http.MethodPost, "/tags/bge/v1/embeddings", strings.NewReader("{}"))
+	if w := doRequest(p, r); w.Code != http.StatusOK {
+		t.Fatalf("hedged request = %d %s", w.Code, w.Body.String())
+	}
+
+	upstream, request := exporter.find("proxy.upstream"), exporter.find("proxy.request")
+	if upstream == nil || request == nil {
+		t.Fatal("upstream or request span was not exported")
+	}
+	upstream.mu.Lock()
+	defer upstream.mu.Unlock()
+	if upstream.attrs["server.address"] != fastNode || upstream.attrs["c3.node"] != fastNode {
+		t.Errorf("upstream span attributes = %v, want the winning node %s", upstream.attrs, fastNode)
+	}
+	if len(upstream.events) != 1 || upstream.events[0].name != "hedge.lost" || upstream.events[0].attrs["server.address"] != slowNode {
+		t.Errorf("upstream span events = %v, want the losing node %s", upstream.events, slowNode)
+	}
+	request.mu.Lock()
+	defer request.mu.Unlock()
+	if request.attrs["c3.node"] != fastNode {
+		t.Errorf("request span c3.node = %v, want %s", request.attrs["c3.node"], fastNode)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testKey passes the API key format checks
+const testKey = "test-key-0123456789"
+
+// newTestProxy builds a proxy from the environment, with env applied on top.
+// API_URL defaults to an unreachable address, and the upstream client trusts
+// the self-signed certificates of newTestNode servers.
+func newTestProxy(t *testing.T, env map[string]string) *ProxyServer {
+	t.Helper()
+	t.Setenv("API_URL", "http://unused.invalid")
+	for name, value := range env {
+		t.Setenv(name, value)
+	}
+
+	p, err := NewProxyServer()
+	if err != nil {
+		t.Fatalf("NewProxyServer: %v", err)
+	}
+	p.upstream = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	return p
+}
+
+// newTestNode starts an HTTPS server standing in for a node and returns its
+// node name (host:port)
+func newTestNode(t *testing.T, handler http.HandlerFunc) string {
+	t.Helper()
+	server := httptest.NewTLSServer(handler)
+	t.Cleanup(server.Close)
+	return strings.TrimPrefix(server.URL, "https://")
+}
+
+// testWorkload returns a running workload an hour from expiry
+func testWorkload(node, id string, tags ...string) Workload {
+	return Workload{
+		Node:     node,
+		Workload: id,
+		Running:  true,
+		Status:   "running",
+		Expires:  time.Now().Add(time.Hour).Unix(),
+		Tags:     tags,
+	}
+}
+
+// seedWorkloads caches workloads for a key as a successful fetch would,
+// without starting the refresh cycle
+func seedWorkloads(p *ProxyServer, apiKey string, workloads ...Workload) {
+	p.cacheLock.Lock()
+	p.workloadCache[apiKey] = &WorkloadCache{LastAccess: time.Now()}
+	p.cacheLock.Unlock()
+	p.updateCache(apiKey, workloads)
+}
+
+// doRequest sends r through the proxy handler, with testKey unless the
+// request already carries an API key
+func doRequest(p *ProxyServer, r *http.Request) *httptest.ResponseRecorder {
+	if r.Header.Get("X-C3-API-KEY") == "" && r.Header.Get("Authorization") == "" {
+		r.Header.Set("X-C3-API-KEY", testKey)
+	}
+	w := httptest.NewRecorder()
+	p.ProxyHandler(w, r)
+	return w
+}
+
+// waitFor polls until check passes, for work done in the background
+func waitFor(t *testing.T, what string, check func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !check() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
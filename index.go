@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Orderings for /{index} routes
+const (
+	indexOrderCreated = "created"
+	indexOrderAPI     = "api"
+)
+
+func loadIndexOrder() (string, error) {
+	switch order := strings.ToLower(os.Getenv("INDEX_ORDER")); order {
+	case "", indexOrderCreated:
+		return indexOrderCreated, nil
+	case indexOrderAPI:
+		return indexOrderAPI, nil
+	default:
+		return "", fmt.Errorf("unknown INDEX_ORDER %q", order)
+	}
+}
+
+// runningWorkloads returns the running workloads in index order. Ordered by
+// creation time, an index only shifts when an older workload stops.
+func (p *ProxyServer) runningWorkloads(workloads []Workload) []Workload {
+	running := make([]Workload, 0, len(workloads))
+	for _, w := range workloads {
+		if w.Running && w.Status == "running" {
+			running = append(running, w)
+		}
+	}
+	if p.indexOrder == indexOrderCreated {
+		sort.SliceStable(running, func(i, j int) bool {
+			if running[i].Created != running[j].Created {
+				return running[i].Created < running[j].Created
+			}
+			return running[i].Workload < running[j].Workload
+		})
+	}
+	return running
+}
+
+// findWorkload returns the running workload with the given workload ID or
+// node name
+func findWorkload(running []Workload, id string) (Workload, bool) {
+	for _, w := range running {
+		if w.Workload == id {
+			return w, true
+		}
+	}
+	for _, w := range running {
+		if w.Node == id {
+			return w, true
+		}
+	}
+	return Workload{}, false
+}
+
+// checkWorkloadRoutable rejects routes that name a workload directly when its
+// node is draining or at capacity
+func (p *ProxyServer) checkWorkloadRoutable(apiKey string, w Workload) error {
+	if p.draining.isDraining(w.Node) {
+		return newProxyError(http.StatusServiceUnavailable, CodeNodeDraining,
+			"Workload %s is on a draining node", w.Workload)
+	}
+	return p.checkNodeCapacity(apiKey, w)
+}
+
+// workloadID returns the ID of the workload an API key runs on a node
+func (p *ProxyServer) workloadID(apiKey, node string) string {
+	p.cacheLock.RLock()
+	defer p.cacheLock.RUnlock()
+	return p.workloadsByNode(apiKey)[node].Workload
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func createdWorkload(node, id string, created int64) Workload {
+	w := testWorkload(node, id, "llama")
+	w.Created = created
+	return w
+}
+
+func workloadIDs(workloads []Workload) []string {
+	ids := make([]string, len(workloads))
+	for i, w := range workloads {
+		ids[i] = w.Workload
+	}
+	return ids
+}
+
+func TestRunningWorkloadsOrder(t *testing.T) {
+	stopped := createdWorkload("node-s", "w-0", 50)
+	stopped.Running = false
+	workloads := []Workload{
+		createdWorkload("node-c", "w-3", 300),
+		createdWorkload("node-b", "w-2b", 200),
+		stopped,
+		createdWorkload("node-a", "w-1", 100),
+		createdWorkload("node-d", "w-2a", 200),
+	}
+
+	tests := map[string][]string{
+		indexOrderCreated: {"w-1", "w-2a", "w-2b", "w-3"},
+		indexOrderAPI:     {"w-3", "w-2b", "w-1", "w-2a"},
+	}
+	for order, want := range tests {
+		p := &ProxyServer{indexOrder: order}
+		if got := workloadIDs(p.runningWorkloads(workloads)); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%s order = %v, want %v", order, got, want)
+		}
+	}
+}
+
+func TestIndexesSurviveAPIReordering(t *testing.T) {
+	p := &ProxyServer{indexOrder: indexOrderCreated}
+	first := workloadIDs(p.runningWorkloads([]Workload{
+		createdWorkload("node-a", "w-1", 100), createdWorkload("node-b", "w-2", 200), createdWorkload("node-c", "w-3", 300),
+	}))
+	reordered := workloadIDs(p.runningWorkloads([]Workload{
+		createdWorkload("node-c", "w-3", 300), createdWorkload("node-a", "w-1", 100), createdWorkload("node-b", "w-2", 200),
+	}))
+	if fmt.Sprint(first) != fmt.Sprint(reordered) {
+		t.Fatalf("indexes moved from %v to %v when the API reordered", first, reordered)
+	}
+
+	// Stopping the newest workload leaves the older indexes alone
+	afterStop := workloadIDs(p.runningWorkloads([]Workload{
+		createdWorkload("node-b", "w-2", 200), createdWorkload("node-a", "w-1", 100),
+	}))
+	if fmt.Sprint(afterStop) != "[w-1 w-2]" {
+		t.Errorf("indexes after the newest workload stopped = %v", afterStop)
+	}
+}
+
+func TestLoadIndexOrder(t *testing.T) {
+	for value, want := range map[string]string{"": indexOrderCreated, "Created": indexOrderCreated, "api": indexOrderAPI} {
+		t.Setenv("INDEX_ORDER", value)
+		if got, err := loadIndexOrder(); err != nil || got != want {
+			t.Errorf("INDEX_ORDER=%q = %q, %v, want %q", value, got, err, want)
+		}
+	}
+	t.Setenv("INDEX_ORDER", "random")
+	if _, err := loadIndexOrder(); err == nil {
+		t.Error("INDEX_ORDER=random was accepted")
+	}
+}
+
+func TestIndexRoutesToOldestWorkload(t *testing.T) {
+	served := make(chan string, 1)
+	older := newTestNode(t, func(w http.ResponseWriter, r *http.Request) { served <- "older" })
+	newer := newTestNode(t, func(w http.ResponseWriter, r *http.Request) { served <- "newer" })
+	p := newTestProxy(t, nil)
+	seedWorkloads(p, testKey, createdWorkload(newer, "w-2", 200), createdWorkload(older, "w-1", 100))
+
+	if w := doRequest(p, httptest.NewRequest(http.MethodGet, "/0/v1/models", nil)); w.Code != http.StatusOK {
+		t.Fatalf("index 0 = %d, want 200", w.Code)
+	}
+	if node := <-served; node != "older" {
+		t.Errorf("index 0 went to the %s workload, want the older one", node)
+	}
+	if w := doRequest(p, httptest.NewRequest(http.MethodGet, "/2/v1/models", nil)); w.Code != http.StatusNotFound {
+		t.Errorf("index 2 of 2 = %d, want 404", w.Code)
+	}
+}
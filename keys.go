@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxRejectedKeys bounds the negative cache so garbage keys cannot grow it forever
+const maxRejectedKeys = 10000
+
+// KeyValidator performs cheap checks on API keys before they reach the
+// Comput3 API and remembers keys the API has rejected
+type KeyValidator struct {
+	mu         sync.Mutex
+	rejected   map[string]time.Time // key hash -> expiry
+	minLength  int
+	maxLength  int
+	rejectTTL  time.Duration
+	maxTracked int
+}
+
+func NewKeyValidator() *KeyValidator {
+	return &KeyValidator{
+		rejected:   make(map[string]time.Time),
+		minLength:  getEnvInt("API_KEY_MIN_LENGTH", 8),
+		maxLength:  getEnvInt("API_KEY_MAX_LENGTH", 256),
+		rejectTTL:  getEnvDuration("INVALID_KEY_TTL", 5*time.Minute),
+		maxTracked: getEnvInt("MAX_TRACKED_KEYS", 1000),
+	}
+}
+
+// hashKey returns a stable, non-reversible identifier for an API key
+func hashKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])
+}
+
+// maskKey returns a prefix of the key that is safe to log
+func maskKey(apiKey string) string {
+	if len(apiKey) > 8 {
+		return apiKey[:8]
+	}
+	return apiKey
+}
+
+// checkFormat rejects keys that cannot possibly be valid
+func (v *KeyValidator) checkFormat(apiKey string) error {
+	if len(apiKey) < v.minLength || len(apiKey) > v.maxLength {
+		return newProxyError(http.StatusUnauthorized, CodeInvalidAPIKey, "API key has an invalid length")
+	}
+	for _, c := range apiKey {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '+' || c == '/' || c == '=') {
+			return newProxyError(http.StatusUnauthorized, CodeInvalidAPIKey, "API key contains invalid characters")
+		}
+	}
+	return nil
+}
+
+// isRejected reports whether the key was recently rejected by the Comput3 API
+func (v *KeyValidator) isRejected(apiKey string) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	hash := hashKey(apiKey)
+	expiry, exists := v.rejected[hash]
+	if !exists {
+		return false
+	}
+	if time.Now().After(expiry) {
+		delete(v.rejected, hash)
+		return false
+	}
+	return true
+}
+
+// reject remembers a key the Comput3 API refused
+func (v *KeyValidator) reject(apiKey string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if len(v.rejected) >= maxRejectedKeys {
+		now := time.Now()
+		for hash, expiry := range v.rejected {
+			if now.After(expiry) {
+				delete(v.rejected, hash)
+			}
+		}
+		if len(v.rejected) >= maxRejectedKeys {
+			v.rejected = make(map[string]time.Time)
+		}
+	}
+	v.rejected[hashKey(apiKey)] = time.Now().Add(v.rejectTTL)
+}
+
+// validateAPIKey checks the key format and the negative cache before any
+// upstream call is made for it
+func (p *ProxyServer) validateAPIKey(apiKey string) error {
+	if err := p.keys.checkFormat(apiKey); err != nil {
+		return err
+	}
+	if p.keys.isRejected(apiKey) {
+		p.logger.Debug("🚫 API key %s... was recently rejected, not contacting the Comput3 API", maskKey(apiKey))
+		return newProxyError(http.StatusUnauthorized, CodeInvalidAPIKey, "API key was rejected by the Comput3 API")
+	}
+	return nil
+}
+
+// isKeyRejection reports whether a fetch error means the key itself is invalid
+func isKeyRejection(err error) bool {
+	var apiErr *WorkloadsAPIError
+	return errors.As(err, &apiErr) &&
+		(apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden)
+}
+
+// recordFetchError remembers keys rejected by the Comput3 API and stops
+// tracking them. It reports whether the key was rejected.
+func (p *ProxyServer) recordFetchError(apiKey string, err error) bool {
+	if !isKeyRejection(err) {
+		return false
+	}
+	p.logger.Warn("🔑 API key %s... rejected by the Comput3 API, caching rejection for %v",
+		maskKey(apiKey), p.keys.rejectTTL)
+	p.keys.reject(apiKey)
+	p.evictKey(apiKey)
+	return true
+}
+
+// ensureTracked creates the cache entry for a key, enforcing MAX_TRACKED_KEYS
+func (p *ProxyServer) ensureTracked(apiKey string) error {
+	p.cacheLock.Lock()
+	defer p.cacheLock.Unlock()
+
+	if _, exists := p.workloadCache[apiKey]; exists {
+		return nil
+	}
+	if p.keys.maxTracked > 0 && len(p.workloadCache) >= p.keys.maxTracked {
+		p.logger.Warn("⚠️  Tracking limit of %d API keys reached, refusing API key %s...",
+			p.keys.maxTracked, maskKey(apiKey))
+		return newProxyError(http.StatusServiceUnavailable, CodeTooManyKeys, "proxy is tracking too many API keys")
+	}
+
+	p.workloadCache[apiKey] = &WorkloadCache{LastAccess: time.Now()}
+	return nil
+}
+
+// evictKey stops the refresh cycle for a key and drops its cached state
+func (p *ProxyServer) evictKey(apiKey string) {
+	p.cacheLock.Lock()
+	defer p.cacheLock.Unlock()
+
+	if cache, exists := p.workloadCache[apiKey]; exists {
+		if cache.StopRefresh != nil {
+			close(cache.StopRefresh)
+		}
+		delete(p.workloadCache, apiKey)
+	}
+	delete(p.tagMappings, apiKey)
+	p.rotation.forget(apiKey)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCheckFormat(t *testing.T) {
+	v := &KeyValidator{minLength: 8, maxLength: 32}
+	tests := map[string]bool{
+		testKey:                 true,
+		"abcDEF12_-.~+/=":       true,
+		"short":                 false,
+		strings.Repeat("k", 33): false,
+		"has spaces in it":      false,
+		"quote\"injection":      false,
+		"unicode-kéy-123":       false,
+	}
+	for key, valid := range tests {
+		if err := v.checkFormat(key); (err == nil) != valid {
+			t.Errorf("checkFormat(%q) = %v, want valid %v", key, err, valid)
+		}
+	}
+}
+
+// keyAPI answers workload fetches with status for keys starting with "bad"
+// and an empty workload list otherwise, counting calls
+func keyAPI(t *testing.T, status int) (string, *atomic.Int32) {
+	t.Helper()
+	calls := new(atomic.Int32)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if strings.HasPrefix(r.Header.Get("X-C3-API-KEY"), "bad") {
+			http.Error(w, "no", status)
+			return
+		}
+		json.NewEncoder(w).Encode([]Workload{testWorkload("node-a", "w-1", "llama")})
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, calls
+}
+
+func workloadsRequest(apiKey string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/workloads", nil)
+	r.Header.Set("X-C3-API-KEY", apiKey)
+	return r
+}
+
+func TestRejectedKeysAreCached(t *testing.T) {
+	url, calls := keyAPI(t, http.StatusUnauthorized)
+	p := newTestProxy(t, map[string]string{"API_URL": url})
+	const badKey = "bad-key-0123456789"
+
+	for i := 0; i < 3; i++ {
+		w := doRequest(p, workloadsRequest(badKey))
+		if w.Code != http.StatusUnauthorized || errorCode(t, w) != CodeInvalidAPIKey {
+			t.Fatalf("request %d = %d, want 401 invalid_api_key", i, w.Code)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Errorf("%d Comput3 API calls for a rejected key, want 1", calls.Load())
+	}
+	p.cacheLock.RLock()
+	_, tracked := p.workloadCache[badKey]
+	p.cacheLock.RUnlock()
+	if tracked {
+		t.Error("a rejected key is still tracked")
+	}
+
+	// Once the rejection expires the key is checked again
+	p.keys.mu.Lock()
+	p.keys.rejected[hashKey(badKey)] = time.Now().Add(-time.Second)
+	p.keys.mu.Unlock()
+	doRequest(p, workloadsRequest(badKey))
+	if calls.Load() != 2 {
+		t.Errorf("%d Comput3 API calls after the rejection expired, want 2", calls.Load())
+	}
+}
+
+func TestMalformedKeysNeverReachTheAPI(t *testing.T) {
+	url, calls := keyAPI(t, http.StatusUnauthorized)
+	p := newTestProxy(t, map[string]string{"API_URL": url})
+
+	for _, key := range []string{"short", "bad key with spaces"} {
+		if w := doRequest(p, workloadsRequest(key)); w.Code != http.StatusUnauthorized {
+			t.Errorf("key %q = %d, want 401", key, w.Code)
+		}
+	}
+	if calls.Load() != 0 {
+		t.Errorf("%d Comput3 API calls for malformed keys, want 0", calls.Load())
+	}
+}
+
+func TestServerErrorsAreNotCachedAsRejections(t *testing.T) {
+	url, calls := keyAPI(t, http.StatusInternalServerError)
+	p := newTestProxy(t, map[string]string{"API_URL": url})
+	const key = "bad-but-valid-0123456789"
+
+	for i := 0; i < 2; i++ {
+		if w := doRequest(p, workloadsRequest(key)); w.Code != http.StatusBadGateway {
+			t.Fatalf("request %d = %d, want 502", i, w.Code)
+		}
+	}
+	if calls.Load() != 2 || p.keys.isRejected(key) {
+		t.Errorf("%d API calls, rejected %v: a server error was cached as a rejection", calls.Load(), p.keys.isRejected(key))
+	}
+}
+
+func TestTrackedKeyLimit(t *testing.T) {
+	url, _ := keyAPI(t, http.StatusUnauthorized)
+	p := newTestProxy(t, map[string]string{"API_URL": url, "MAX_TRACKED_KEYS": "1"})
+
+	if w := doRequest(p, workloadsRequest(testKey)); w.Code != http.StatusOK {
+		t.Fatalf("first key = %d, want 200", w.Code)
+	}
+	if w := doRequest(p, workloadsRequest("other-key-0123456789")); w.Code != http.StatusServiceUnavailable || errorCode(t, w) != CodeTooManyKeys {
+		t.Errorf("second key = %d, want 503 too_many_keys", w.Code)
+	}
+	if w := doRequest(p, workloadsRequest(testKey)); w.Code != http.StatusOK {
+		t.Errorf("tracked key after the limit = %d, want 200", w.Code)
+	}
+}
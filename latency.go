@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Balancing modes for GetLeastBusyNode
+const (
+	balancerLeastBusy = "least_busy"
+	balancerLatency   = "latency"
+	balancerWeighted  = "weighted"
+)
+
+// minThroughputBytes is the smallest response used for throughput samples
+const minThroughputBytes = 1024
+
+func loadBalancerMode() (string, error) {
+	switch mode := strings.ToLower(os.Getenv("BALANCER")); mode {
+	case "", balancerLeastBusy:
+		return balancerLeastBusy, nil
+	case balancerLatency:
+		return balancerLatency, nil
+	case balancerWeighted:
+		return balancerWeighted, nil
+	default:
+		return "", fmt.Errorf("unknown BALANCER %q", mode)
+	}
+}
+
+// nodeLatency holds a node's moving averages
+type nodeLatency struct {
+	ttfb       time.Duration
+	throughput float64 // bytes per second while streaming
+	bytes      float64 // response size
+	updated    time.Time
+}
+
+// latencyTracker keeps an exponentially weighted moving average of each
+// node's time to first byte, streaming throughput and response size. Averages not updated
+// within maxAge are ignored so a node that was slow once is tried again.
+type latencyTracker struct {
+	mu     sync.RWMutex
+	alpha  float64
+	maxAge time.Duration
+	nodes  map[string]*nodeLatency
+}
+
+func newLatencyTracker(logger *Logger) *latencyTracker {
+	alpha := 0.3
+	if value := os.Getenv("LATENCY_EWMA_ALPHA"); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed <= 0 || parsed > 1 {
+			logger.Warn("⚠️  Invalid value %q for LATENCY_EWMA_ALPHA, using default", value)
+		} else {
+			alpha = parsed
+		}
+	}
+	return &latencyTracker{
+		alpha:  alpha,
+		maxAge: getEnvDuration("LATENCY_EWMA_MAX_AGE", 5*time.Minute),
+		nodes:  make(map[string]*nodeLatency),
+	}
+}
+
+func (t *latencyTracker) entry(node string) *nodeLatency {
+	entry, exists := t.nodes[node]
+	if !exists {
+		entry = &nodeLatency{}
+		t.nodes[node] = entry
+	}
+	return entry
+}
+
+// recordTTFB adds a time-to-first-byte sample
+func (t *latencyTracker) recordTTFB(node string, ttfb time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry := t.entry(node)
+	if entry.ttfb == 0 || time.Since(entry.updated) > t.maxAge {
+		entry.ttfb = ttfb
+	} else {
+		entry.ttfb = time.Duration(t.alpha*float64(ttfb) + (1-t.alpha)*float64(entry.ttfb))
+	}
+	entry.updated = time.Now()
+}
+
+// recordThroughput adds a response size sample and, for responses of at least
+// minThroughputBytes, a streaming throughput sample
+func (t *latencyTracker) recordThroughput(node string, bytes int64, elapsed time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry := t.entry(node)
+	if entry.bytes == 0 {
+		entry.bytes = float64(bytes)
+	} else {
+		entry.bytes = t.alpha*float64(bytes) + (1-t.alpha)*entry.bytes
+	}
+
+	if bytes < minThroughputBytes || elapsed <= 0 {
+		return
+	}
+	rate := float64(bytes) / elapsed.Seconds()
+	if entry.throughput == 0 {
+		entry.throughput = rate
+	} else {
+		entry.throughput = t.alpha*rate + (1-t.alpha)*entry.throughput
+	}
+}
+
+// snapshot returns the current averages for a node
+func (t *latencyTracker) snapshot(node string) (nodeLatency, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	entry, exists := t.nodes[node]
+	if !exists || entry.ttfb == 0 || time.Since(entry.updated) > t.maxAge {
+		return nodeLatency{}, false
+	}
+	return *entry, true
+}
+
+// pick returns the node with the lowest expected completion time, scored as
+// (EWMA time to first byte + typical response size / EWMA throughput) ×
+// (in-flight+1). The typical size is the mean over all candidates, so every
+// node is scored for the same response. Nodes without recent samples are
+// scored with the mean of the others; without throughput samples only time to
+// first byte counts, and with no samples at all this is least busy.
+func (t *latencyTracker) pick(nodes []string, inFlight map[string]int) (string, time.Duration) {
+	samples := make(map[string]nodeLatency, len(nodes))
+	var ttfbTotal time.Duration
+	var bytesTotal, throughputTotal float64
+	var sized, rated int
+	for _, node := range nodes {
+		entry, ok := t.snapshot(node)
+		if !ok {
+			continue
+		}
+		samples[node] = entry
+		ttfbTotal += entry.ttfb
+		if entry.bytes > 0 {
+			bytesTotal += entry.bytes
+			sized++
+		}
+		if entry.throughput > 0 {
+			throughputTotal += entry.throughput
+			rated++
+		}
+	}
+	fallbackTTFB := time.Millisecond
+	if len(samples) > 0 {
+		fallbackTTFB = ttfbTotal / time.Duration(len(samples))
+	}
+	var size, fallbackThroughput float64
+	if sized > 0 {
+		size = bytesTotal / float64(sized)
+	}
+	if rated > 0 {
+		fallbackThroughput = throughputTotal / float64(rated)
+	}
+
+	var selected string
+	var best time.Duration = -1
+	for _, node := range nodes {
+		ttfb, throughput := fallbackTTFB, fallbackThroughput
+		if entry, ok := samples[node]; ok {
+			ttfb = entry.ttfb
+			if entry.throughput > 0 {
+				throughput = entry.throughput
+			}
+		}
+		expected := ttfb
+		if size > 0 && throughput > 0 {
+			expected += time.Duration(size / throughput * float64(time.Second))
+		}
+		score := expected * time.Duration(inFlight[node]+1)
+		if best < 0 || score < best {
+			best = score
+			selected = node
+		}
+	}
+	return selected, best
+}
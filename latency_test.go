@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyPickUsesThroughput(t *testing.T) {
+	tracker := newLatencyTracker(NewLogger("test"))
+
+	// Both nodes answer quickly, but fast streams 10x more bytes per second
+	tracker.recordTTFB("fast", 100*time.Millisecond)
+	tracker.recordThroughput("fast", 100_000, 100*time.Millisecond)
+	tracker.recordTTFB("slow", 50*time.Millisecond)
+	tracker.recordThroughput("slow", 100_000, time.Second)
+
+	if node, _ := tracker.pick([]string{"slow", "fast"}, nil); node != "fast" {
+		t.Errorf("pick = %s, want fast", node)
+	}
+
+	// Enough load on the faster node still sends requests elsewhere
+	if node, _ := tracker.pick([]string{"slow", "fast"}, map[string]int{"fast": 9}); node != "slow" {
+		t.Errorf("pick with a busy fast node = %s, want slow", node)
+	}
+}
+
+func TestLatencyPickWithoutThroughputUsesTTFB(t *testing.T) {
+	tracker := newLatencyTracker(NewLogger("test"))
+	tracker.recordTTFB("a", 200*time.Millisecond)
+	tracker.recordTTFB("b", 100*time.Millisecond)
+
+	node, score := tracker.pick([]string{"a", "b"}, nil)
+	if node != "b" || score != 100*time.Millisecond {
+		t.Errorf("pick = %s (%v), want b (100ms)", node, score)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	errBodyTooLarge  = errors.New("request body too large")
+	errHeaderTimeout = errors.New("upstream response header timeout")
+	errIdleTimeout   = errors.New("upstream stream idle timeout")
+	errTotalTimeout  = errors.New("request deadline exceeded")
+)
+
+// RouteLimits bounds the size and duration of a proxied request. Zero values
+// mean no limit, or inherit the default in a per-tag override; negative values
+// disable a limit the default would apply.
+type RouteLimits struct {
+	MaxBodyBytes  int64    `json:"max_body_bytes,omitempty"`
+	HeaderTimeout Duration `json:"header_timeout,omitempty"`
+	IdleTimeout   Duration `json:"idle_timeout,omitempty"`
+	TotalTimeout  Duration `json:"total_timeout,omitempty"`
+}
+
+// LimitsConfig holds the default limits and per-tag overrides
+type LimitsConfig struct {
+	Default RouteLimits            `json:"default"`
+	Tags    map[string]RouteLimits `json:"tags,omitempty"`
+}
+
+// loadLimitsConfig builds the defaults from environment variables and then
+// applies ROUTE_LIMITS (or ROUTE_LIMITS_FILE) on top
+func loadLimitsConfig(logger *Logger) (*LimitsConfig, error) {
+	cfg := &LimitsConfig{
+		Default: RouteLimits{
+			MaxBodyBytes:  int64(getEnvInt("MAX_REQUEST_BODY_BYTES", 0)),
+			HeaderTimeout: Duration(getEnvDuration("UPSTREAM_HEADER_TIMEOUT", 0)),
+			IdleTimeout:   Duration(getEnvDuration("STREAM_IDLE_TIMEOUT", 0)),
+			TotalTimeout:  Duration(getEnvDuration("REQUEST_TIMEOUT", 0)),
+		},
+	}
+
+	var overrides LimitsConfig
+	found, err := loadJSONEnv("ROUTE_LIMITS", &overrides)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		cfg.Default = cfg.Default.merge(overrides.Default)
+		cfg.Tags = overrides.Tags
+		logger.Info("⏱️  Loaded route limits for %d tags", len(cfg.Tags))
+	}
+	return cfg, nil
+}
+
+// merge returns l with every non-zero field of override applied, including
+// negative ones so a tag can turn off a default limit
+func (l RouteLimits) merge(override RouteLimits) RouteLimits {
+	if override.MaxBodyBytes != 0 {
+		l.MaxBodyBytes = override.MaxBodyBytes
+	}
+	if override.HeaderTimeout != 0 {
+		l.HeaderTimeout = override.HeaderTimeout
+	}
+	if override.IdleTimeout != 0 {
+		l.IdleTimeout = override.IdleTimeout
+	}
+	if override.TotalTimeout != 0 {
+		l.TotalTimeout = override.TotalTimeout
+	}
+	return l
+}
+
+// forTag returns the effective limits for a tag
+func (c *LimitsConfig) forTag(tag string) RouteLimits {
+	if override, ok := c.Tags[tag]; ok && tag != "" {
+		return c.Default.merge(override)
+	}
+	return c.Default
+}
+
+// limitedBody wraps a request body and fails once more than max bytes are read
+type limitedBody struct {
+	body     io.ReadCloser
+	max      int64
+	read     int64
+	exceeded atomic.Bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	b.read += int64(n)
+	if b.read > b.max {
+		b.exceeded.Store(true)
+		return 0, errBodyTooLarge
+	}
+	return n, err
+}
+
+func (b *limitedBody) Close() error {
+	return b.body.Close()
+}
+
+// requestDeadlines enforces the header, idle and total timeouts of a single
+// upstream call by cancelling its context with the matching cause
+type requestDeadlines struct {
+	ctx         context.Context
+	cancel      context.CancelCauseFunc
+	cancelTotal context.CancelFunc
+	limits      RouteLimits
+
+	mu          sync.Mutex
+	headerTimer *time.Timer
+	idleTimer   *time.Timer
+}
+
+func newRequestDeadlines(parent context.Context, limits RouteLimits) *requestDeadlines {
+	d := &requestDeadlines{limits: limits, cancelTotal: func() {}}
+
+	ctx := parent
+	if limits.TotalTimeout > 0 {
+		ctx, d.cancelTotal = context.WithTimeoutCause(parent, time.Duration(limits.TotalTimeout), errTotalTimeout)
+	}
+	d.ctx, d.cancel = context.WithCancelCause(ctx)
+
+	if limits.HeaderTimeout > 0 {
+		d.headerTimer = time.AfterFunc(time.Duration(limits.HeaderTimeout), func() { d.cancel(errHeaderTimeout) })
+	}
+	return d
+}
+
+// headersReceived stops the header timer and starts idle tracking
+func (d *requestDeadlines) headersReceived() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.headerTimer != nil {
+		d.headerTimer.Stop()
+	}
+	if d.limits.IdleTimeout > 0 {
+		d.idleTimer = time.AfterFunc(time.Duration(d.limits.IdleTimeout), func() { d.cancel(errIdleTimeout) })
+	}
+}
+
+// activity resets the idle timer after data was received from the node
+func (d *requestDeadlines) activity() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.idleTimer != nil {
+		d.idleTimer.Reset(time.Duration(d.limits.IdleTimeout))
+	}
+}
+
+// cause returns the timeout that cancelled the request, or nil
+func (d *requestDeadlines) cause() error {
+	cause := context.Cause(d.ctx)
+	if errors.Is(cause, errHeaderTimeout) || errors.Is(cause, errIdleTimeout) || errors.Is(cause, errTotalTimeout) {
+		return cause
+	}
+	return nil
+}
+
+// stop releases all timers
+func (d *requestDeadlines) stop() {
+	d.mu.Lock()
+	if d.headerTimer != nil {
+		d.headerTimer.Stop()
+	}
+	if d.idleTimer != nil {
+		d.idleTimer.Stop()
+	}
+	d.mu.Unlock()
+	d.cancel(context.Canceled)
+	d.cancelTotal()
+}
+
+// idleReader resets the idle deadline whenever data arrives from the node
+type idleReader struct {
+	body      io.Reader
+	deadlines *requestDeadlines
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	if n > 0 {
+		r.deadlines.activity()
+	}
+	return n, err
+}
+
+// logTimeout writes a distinct log entry for each kind of timeout
+func (p *ProxyServer) logTimeout(cause error, node string, limits RouteLimits) {
+	switch {
+	case errors.Is(cause, errHeaderTimeout):
+		p.logger.Warn("⏰ Node %s sent no response headers within %v", node, time.Duration(limits.HeaderTimeout))
+	case errors.Is(cause, errIdleTimeout):
+		p.logger.Warn("⏰ Stream from node %s idle for more than %v, aborting", node, time.Duration(limits.IdleTimeout))
+	case errors.Is(cause, errTotalTimeout):
+		p.logger.Warn("⏰ Request to node %s exceeded total deadline of %v", node, time.Duration(limits.TotalTimeout))
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLimitsForTag(t *testing.T) {
+	t.Setenv("MAX_REQUEST_BODY_BYTES", "1000")
+	t.Setenv("UPSTREAM_HEADER_TIMEOUT", "10s")
+	t.Setenv("ROUTE_LIMITS", `{
+		"default": {"total_timeout": "1m"},
+		"tags": {"bge": {"max_body_bytes": 50}, "llama": {"header_timeout": "-1s", "idle_timeout": "5m"}}
+	}`)
+	cfg, err := loadLimitsConfig(NewLogger("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]RouteLimits{
+		"":      {MaxBodyBytes: 1000, HeaderTimeout: Duration(10 * time.Second), TotalTimeout: Duration(time.Minute)},
+		"other": {MaxBodyBytes: 1000, HeaderTimeout: Duration(10 * time.Second), TotalTimeout: Duration(time.Minute)},
+		"bge":   {MaxBodyBytes: 50, HeaderTimeout: Duration(10 * time.Second), TotalTimeout: Duration(time.Minute)},
+		"llama": {MaxBodyBytes: 1000, HeaderTimeout: Duration(-time.Second), IdleTimeout: Duration(5 * time.Minute), TotalTimeout: Duration(time.Minute)},
+	}
+	for tag, want := range tests {
+		if got := cfg.forTag(tag); got != want {
+			t.Errorf("forTag(%q) = %+v, want %+v", tag, got, want)
+		}
+	}
+}
+
+// limitedProxy routes tag llama to handler with the given limits
+func limitedProxy(t *testing.T, limits string, handler http.HandlerFunc) *ProxyServer {
+	t.Helper()
+	node := newTestNode(t, handler)
+	p := newTestProxy(t, map[string]string{"ROUTE_LIMITS": limits})
+	seedWorkloads(p, testKey, testWorkload(node, "w-1", "llama"))
+	return p
+}
+
+func errorCode(t *testing.T, w *httptest.ResponseRecorder) ErrorCode {
+	t.Helper()
+	var body errorBody
+	json.NewDecoder(w.Body).Decode(&body)
+	return body.Code
+}
+
+func TestBodyLimit(t *testing.T) {
+	p := limitedProxy(t, `{"default": {"max_body_bytes": 10}}`, func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+	})
+
+	r := httptest.NewRequest(http.MethodPost, "/tags/llama/v1/chat", strings.NewReader("0123456789"))
+	if w := doRequest(p, r); w.Code != http.StatusOK {
+		t.Errorf("body at the limit = %d, want 200", w.Code)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/tags/llama/v1/chat", strings.NewReader("0123456789x"))
+	if w := doRequest(p, r); w.Code != http.StatusRequestEntityTooLarge || errorCode(t, w) != CodeRequestTooLarge {
+		t.Errorf("declared oversized body = %d, want 413 request_too_large", w.Code)
+	}
+
+	// A body without a declared length is cut off once it passes the limit
+	r = httptest.NewRequest(http.MethodPost, "/tags/llama/v1/chat", io.NopCloser(strings.NewReader(strings.Repeat("x", 100))))
+	r.ContentLength = -1
+	if w := doRequest(p, r); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("streamed oversized body = %d, want 413", w.Code)
+	}
+}
+
+func slowHeaders(delay time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+		}
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	tests := []struct {
+		name, limits string
+		wantStatus   int
+		wantCode     ErrorCode
+	}{
+		{"header timeout", `{"default": {"header_timeout": "50ms"}}`, http.StatusGatewayTimeout, CodeUpstreamTimeout},
+		{"total timeout", `{"default": {"total_timeout": "50ms"}}`, http.StatusGatewayTimeout, CodeRequestTimeout},
+		{"disabled for the tag", `{"default": {"header_timeout": "50ms"}, "tags": {"llama": {"header_timeout": "-1s"}}}`, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := limitedProxy(t, tt.limits, slowHeaders(300*time.Millisecond))
+			w := doRequest(p, httptest.NewRequest(http.MethodGet, "/tags/llama/v1/models", nil))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantCode != "" {
+				if code := errorCode(t, w); code != tt.wantCode {
+					t.Errorf("code = %s, want %s", code, tt.wantCode)
+				}
+			}
+		})
+	}
+}
+
+func TestIdleTimeoutAbortsStream(t *testing.T) {
+	p := limitedProxy(t, `{"default": {"idle_timeout": "50ms"}}`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("first chunk"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-time.After(2 * time.Second):
+			w.Write([]byte(" late chunk"))
+		case <-r.Context().Done():
+		}
+	})
+
+	start := time.Now()
+	w := doRequest(p, httptest.NewRequest(http.MethodGet, "/tags/llama/v1/stream", nil))
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("stalled stream ran for %v, want it aborted after about 50ms", elapsed)
+	}
+	if w.Body.String() != "first chunk" {
+		t.Errorf("body = %q, want only the chunk sent before the stall", w.Body.String())
+	}
+}
@@ -1,9 +1,30 @@
 package main
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
 
 // GetLeastBusyNode returns the node with the least number of in-flight requests
 func (p *ProxyServer) GetLeastBusyNode(apiKey string, tag string) (string, error) {
+	return p.getLeastBusyNode(context.Background(), apiKey, tag)
+}
+
+// getLeastBusyNode selects a node within the trace carried by ctx, skipping
+// any excluded nodes
+func (p *ProxyServer) getLeastBusyNode(ctx context.Context, apiKey string, tag string, exclude ...string) (node string, err error) {
+	ctx, span := p.tracer.Start(ctx, "proxy.select_node", spanKindInternal)
+	span.SetAttr("c3.tag", tag)
+	defer func() {
+		span.RecordError(err)
+		span.SetAttr("c3.node", node)
+		span.End()
+	}()
+
 	// Check if we need to refresh workloads first
 	p.cacheLock.RLock()
 	cache, exists := p.workloadCache[apiKey]
@@ -20,12 +41,18 @@ func (p *ProxyServer) GetLeastBusyNode(apiKey string, tag string) (string, error
 
 	// If no nodes exist and we haven't refreshed recently, force a refresh
 	if !nodesExist {
-		p.logger.Debug("🔍 No nodes found for API key %s... - forcing workload refresh", apiKey[:8])
-		if _, err := p.forceRefreshWorkloads(apiKey); err != nil {
-			return "", fmt.Errorf("failed to refresh workloads: %v", err)
+		p.logger.Debug("🔍 No nodes found for API key %s... - forcing workload refresh", maskKey(apiKey))
+		if _, err := p.forceRefreshWorkloads(ctx, apiKey); err != nil {
+			return "", fmt.Errorf("failed to refresh workloads: %w", err)
 		}
 	}
 
+	// In-flight counts from other proxy replicas, if state is shared
+	peers, err := p.state.PeerInFlight(apiKey)
+	if err != nil {
+		p.logger.Warn("⚠️  Failed to read peer in-flight counts, balancing on local counts: %v", err)
+	}
+
 	p.requestLock.RLock()
 	defer p.requestLock.RUnlock()
 
@@ -37,7 +64,7 @@ func (p *ProxyServer) GetLeastBusyNode(apiKey string, tag string) (string, error
 	if tag == "all" {
 		// Make sure we have a valid workload cache before proceeding
 		if _, exists := p.workloadCache[apiKey]; !exists || p.workloadCache[apiKey].Workloads == nil {
-			return "", fmt.Errorf("no workloads found for API key")
+			return "", newProxyError(http.StatusServiceUnavailable, CodeNoHealthyNodes, "no workloads found for API key")
 		}
 
 		for _, workload := range p.workloadCache[apiKey].Workloads {
@@ -47,21 +74,34 @@ func (p *ProxyServer) GetLeastBusyNode(apiKey string, tag string) (string, error
 		}
 		p.logger.Debug("🎯 Using all nodes for tag 'all': %v", nodes)
 	} else {
-		tagMap, exists := p.tagMappings[apiKey]
-		if !exists {
-			return "", fmt.Errorf("no nodes found for API key")
+		nodes, err = p.nodesForSelector(apiKey, tag)
+		if err != nil {
+			return "", err
 		}
+	}
 
-		nodes, exists = tagMap[tag]
-		if !exists || len(nodes) == 0 {
-			return "", fmt.Errorf("no nodes found for tag: %s", tag)
-		}
+	if len(nodes) == 0 {
+		return "", newProxyError(http.StatusServiceUnavailable, CodeNoHealthyNodes, "no active nodes found")
 	}
 
+	nodes = p.withoutDraining(nodes)
 	if len(nodes) == 0 {
-		return "", fmt.Errorf("no active nodes found")
+		return "", newProxyError(http.StatusServiceUnavailable, CodeNodeDraining, "all matching nodes are draining")
 	}
 
+	if len(exclude) > 0 {
+		nodes = withoutNodes(nodes, exclude)
+		if len(nodes) == 0 {
+			return "", newProxyError(http.StatusServiceUnavailable, CodeNoHealthyNodes, "no other nodes available")
+		}
+	}
+
+	nodes, err = p.preferLongLived(apiKey, nodes)
+	if err != nil {
+		return "", err
+	}
+	span.SetAttr("c3.candidates", len(nodes))
+
 	if _, exists := p.inFlightRequests[apiKey]; !exists {
 		p.requestLock.RUnlock()
 		p.requestLock.Lock()
@@ -70,10 +110,37 @@ func (p *ProxyServer) GetLeastBusyNode(apiKey string, tag string) (string, error
 		p.requestLock.RLock()
 	}
 
+	inFlight := make(map[string]int, len(nodes))
+	for _, node := range nodes {
+		inFlight[node] = p.inFlightRequests[apiKey][node] + peers[node]
+	}
+	span.SetAttr("c3.balancer", p.balancer)
+
+	candidates := nodes
+	capacities := p.nodeCapacities(apiKey, nodes)
+	nodes, err = p.withinCapacity(nodes, capacities, inFlight)
+	if err != nil {
+		return "", err
+	}
+
+	if p.balancer == balancerWeighted {
+		selectedNode := p.rotation.next(apiKey, candidates, nodes, capacities)
+		p.logger.Debug("⚖️  Weighted balancing: selected node %s with %d in-flight requests (weight %.2f)",
+			selectedNode, inFlight[selectedNode], capacities[selectedNode].Weight)
+		return selectedNode, nil
+	}
+
+	if p.balancer == balancerLatency {
+		selectedNode, score := p.latency.pick(nodes, inFlight)
+		p.logger.Debug("⚖️  Latency balancing: selected node %s with %d in-flight requests (expected %v)",
+			selectedNode, inFlight[selectedNode], score.Round(time.Millisecond))
+		return selectedNode, nil
+	}
+
 	var selectedNode string
 	minRequests := -1
 	for _, node := range nodes {
-		requests := p.inFlightRequests[apiKey][node]
+		requests := inFlight[node]
 		if minRequests == -1 || requests < minRequests {
 			minRequests = requests
 			selectedNode = node
@@ -84,11 +151,58 @@ func (p *ProxyServer) GetLeastBusyNode(apiKey string, tag string) (string, error
 	return selectedNode, nil
 }
 
-// TrackRequest updates the count of in-flight requests for a node
+// withoutNodes returns nodes minus the excluded ones
+func withoutNodes(nodes, exclude []string) []string {
+	remaining := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		if !slices.Contains(exclude, node) {
+			remaining = append(remaining, node)
+		}
+	}
+	return remaining
+}
+
+// trackInFlight counts a request against a node until the returned function
+// is called; calling it more than once has no further effect
+func (p *ProxyServer) trackInFlight(apiKey, node string) func() {
+	p.logger.Debug("📈 Incrementing in-flight count for node %s", node)
+	p.TrackRequest(apiKey, node, 1)
+	if currentLogLevel() == DEBUG {
+		p.DumpInFlightRequests() // Debug in-flight requests
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			p.logger.Debug("📉 Decrementing in-flight count for node %s", node)
+			p.TrackRequest(apiKey, node, -1)
+			if currentLogLevel() == DEBUG {
+				p.DumpInFlightRequests() // Debug in-flight requests
+			}
+		})
+	}
+}
+
+// TrackRequest updates the count of in-flight requests for a node and
+// publishes it to the state backend
 func (p *ProxyServer) TrackRequest(apiKey, node string, delta int) {
 	p.requestLock.Lock()
-	defer p.requestLock.Unlock()
+	count := p.trackRequest(apiKey, node, delta)
+	// Publishing only queues the count, so it is done under the lock to keep
+	// the counts for a node in order
+	if err := p.state.PublishInFlight(apiKey, node, count); err != nil {
+		p.logger.Debug("⚠️  Failed to publish in-flight count for node %s: %v", node, err)
+	}
+	p.requestLock.Unlock()
 
+	if delta < 0 {
+		p.nodeDrained(node, count)
+	}
+}
+
+// trackRequest updates the local in-flight count and returns the new value;
+// callers must hold requestLock
+func (p *ProxyServer) trackRequest(apiKey, node string, delta int) int {
 	if _, exists := p.inFlightRequests[apiKey]; !exists {
 		p.inFlightRequests[apiKey] = make(map[string]int)
 	}
@@ -99,7 +213,7 @@ func (p *ProxyServer) TrackRequest(apiKey, node string, delta int) {
 		p.logger.Warn("⚠️  Attempted to decrement in-flight requests below zero for node %s (current: %d, delta: %d)",
 			node, currentCount, delta)
 		p.inFlightRequests[apiKey][node] = 0
-		return
+		return 0
 	}
 
 	// If we're decrementing, ensure we never go below zero
@@ -107,7 +221,7 @@ func (p *ProxyServer) TrackRequest(apiKey, node string, delta int) {
 		p.logger.Warn("⚠️  Would decrement below zero for node %s (current: %d, delta: %d), setting to 0 instead",
 			node, currentCount, delta)
 		p.inFlightRequests[apiKey][node] = 0
-		return
+		return 0
 	}
 
 	p.inFlightRequests[apiKey][node] += delta
@@ -118,4 +232,6 @@ func (p *ProxyServer) TrackRequest(apiKey, node string, delta int) {
 			node, p.inFlightRequests[apiKey][node])
 		p.inFlightRequests[apiKey][node] = 0
 	}
+
+	return p.inFlightRequests[apiKey][node]
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -31,7 +32,14 @@ func (l LogLevel) String() string {
 	}
 }
 
-var logLevel = INFO
+// logLevel is read by every log call, including from request goroutines, so it
+// is stored atomically
+var logLevel atomic.Int32
+
+func init() { logLevel.Store(int32(INFO)) }
+
+// currentLogLevel returns the level set by setLogLevel
+func currentLogLevel() LogLevel { return LogLevel(logLevel.Load()) }
 
 type Logger struct {
 	prefix string
@@ -42,7 +50,7 @@ func NewLogger(prefix string) *Logger {
 }
 
 func (l *Logger) log(level LogLevel, format string, v ...interface{}) {
-	if level >= logLevel {
+	if level >= currentLogLevel() {
 		timestamp := time.Now().Format("2006-01-02 15:04:05.000")
 		message := fmt.Sprintf(format, v...)
 		prefix := level.String()
@@ -73,7 +81,7 @@ func setLogLevel(level string) LogLevel {
 		log.Printf("⚠️  Invalid log level %s, using INFO", level)
 		newLevel = INFO
 	}
-	logLevel = newLevel
+	logLevel.Store(int32(newLevel))
 	log.Printf("📊 Setting log level to %s", newLevel)
 	return newLevel
 }
\ No newline at end of file
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// Counter is a monotonically increasing metric
+type Counter struct {
+	name  string
+	help  string
+	value atomic.Int64
+}
+
+func (c *Counter) Inc()         { c.value.Add(1) }
+func (c *Counter) Add(n int64)  { c.value.Add(n) }
+func (c *Counter) Value() int64 { return c.value.Load() }
+
+// gaugeFunc is a metric whose value is computed when it is scraped
+type gaugeFunc struct {
+	name string
+	help string
+	fn   func() float64
+}
+
+// Metrics is a minimal registry exposed in the Prometheus text format
+type Metrics struct {
+	mu       sync.Mutex
+	counters []*Counter
+	gauges   []gaugeFunc
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{}
+}
+
+// NewCounter registers a counter under the given name
+func (m *Metrics) NewCounter(name, help string) *Counter {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := &Counter{name: name, help: help}
+	m.counters = append(m.counters, c)
+	return c
+}
+
+// NewGaugeFunc registers a gauge computed by fn on every scrape
+func (m *Metrics) NewGaugeFunc(name, help string, fn func() float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.gauges = append(m.gauges, gaugeFunc{name: name, help: help, fn: fn})
+}
+
+// WritePrometheus writes all registered metrics in the Prometheus text format
+func (m *Metrics) WritePrometheus(w io.Writer) {
+	m.mu.Lock()
+	counters := append([]*Counter{}, m.counters...)
+	gauges := append([]gaugeFunc{}, m.gauges...)
+	m.mu.Unlock()
+
+	for _, c := range counters {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", c.name, c.help, c.name, c.name, c.Value())
+	}
+	for _, g := range gauges {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %g\n", g.name, g.help, g.name, g.name, g.fn())
+	}
+}
+
+// MetricsHandler serves the metrics registry
+func (p *ProxyServer) MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	p.metrics.WritePrometheus(w)
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -12,52 +14,132 @@ import (
 
 // HandleProxyRequest handles proxying a single request to a node
 func (p *ProxyServer) HandleProxyRequest(w http.ResponseWriter, r *http.Request, node string, apiKey string) {
-	targetURL := fmt.Sprintf("https://%s%s", node, r.URL.Path)
-	if r.URL.RawQuery != "" {
-		targetURL += "?" + r.URL.RawQuery
-	}
+	info := getRequestInfo(r)
+	limits := p.limits.forTag(info.Tag)
 
-	proxyReq, err := http.NewRequest(r.Method, targetURL, r.Body)
-	if err != nil {
-		p.logger.Debug("❌ Error creating proxy request: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if limits.MaxBodyBytes > 0 && r.ContentLength > limits.MaxBodyBytes {
+		p.logger.Warn("📦 Request body of %d bytes exceeds limit of %d bytes for tag %s",
+			r.ContentLength, limits.MaxBodyBytes, info.Tag)
+		p.writeError(w, r, errBodyTooLarge)
 		return
 	}
 
-	copyHeader(proxyReq.Header, r.Header)
-	proxyReq.Header.Set("Host", node)
-
-	p.logger.Debug("📈 Incrementing in-flight count for node %s", node)
-	p.TrackRequest(apiKey, node, 1)
-	if logLevel == DEBUG {
-		p.DumpInFlightRequests() // Debug in-flight requests
+	var limited *limitedBody
+	body := io.Reader(r.Body)
+	if limits.MaxBodyBytes > 0 && r.Body != nil && r.Body != http.NoBody {
+		limited = &limitedBody{body: r.Body, max: limits.MaxBodyBytes}
+		body = limited
 	}
 
-	// Use defer to ensure we decrement even if there's a panic
-	defer func() {
-		p.logger.Debug("📉 Decrementing in-flight count for node %s", node)
-		p.TrackRequest(apiKey, node, -1)
-		if logLevel == DEBUG {
-			p.DumpInFlightRequests() // Debug in-flight requests
+	// Hedged requests may be sent twice, so their body is buffered. Upgrades
+	// hold a connection open and are never sent twice.
+	hedge := p.hedging.forTag(info.Tag)
+	var replay []byte
+	if hedge.canReplay(r) && upgradeType(r.Header) == "" {
+		var err error
+		if replay, err = io.ReadAll(body); err != nil {
+			p.logger.Debug("❌ Error reading request body: %v", err)
+			p.writeError(w, r, newProxyError(http.StatusBadRequest, CodeInvalidRequest, "failed to read request body").wrap(err))
+			return
 		}
-	}()
+		body = bytes.NewReader(replay)
+	} else {
+		hedge = nil
+	}
 
-	p.logger.Debug("📡 Proxying request to %s: %s %s", node, r.Method, targetURL)
+	deadlines := newRequestDeadlines(r.Context(), limits)
+	defer deadlines.stop()
 
-	client := &http.Client{}
-	resp, err := client.Do(proxyReq)
+	proxyReq, err := p.newUpstreamRequest(deadlines.ctx, r, node, apiKey, body)
 	if err != nil {
+		p.logger.Debug("❌ Error creating proxy request: %v", err)
+		p.writeError(w, r, err)
+		return
+	}
+
+	_, upstreamSpan := p.tracer.Start(r.Context(), "proxy.upstream", spanKindClient)
+	upstreamSpan.SetAttr("server.address", node)
+	upstreamSpan.SetAttr("http.request.method", r.Method)
+	upstreamSpan.inject(proxyReq.Header)
+	defer upstreamSpan.End()
+
+	p.logger.Debug("📡 Proxying request to %s: %s %s", node, r.Method, proxyReq.URL)
+
+	queued := time.Since(info.Start)
+	attempt := p.sendUpstream(r, proxyReq, apiKey, node, hedge, replay)
+	defer attempt.release()
+	if attempt.node != node {
+		// A hedge answered first, so the spans describe the node that served the response
+		upstreamSpan.SetAttr("server.address", attempt.node)
+		spanFromContext(r.Context()).SetAttr("c3.node", attempt.node)
+	}
+	upstreamSpan.SetAttr("c3.node", attempt.node)
+	for _, lost := range attempt.lost {
+		upstreamSpan.AddEvent("hedge.lost", map[string]interface{}{
+			"server.address": lost.node,
+			"c3.elapsed_ms":  lost.elapsed.Milliseconds(),
+			"c3.failed":      lost.failed,
+		})
+	}
+	node = attempt.node
+	sent, resp, err := attempt.sent, attempt.resp, attempt.err
+	if err != nil {
+		upstreamSpan.RecordError(err)
+		if limited != nil && limited.exceeded.Load() {
+			p.logger.Warn("📦 Request body exceeded limit of %d bytes for tag %s", limits.MaxBodyBytes, info.Tag)
+			p.writeError(w, r, errBodyTooLarge)
+			return
+		}
+		if r.Context().Err() == nil {
+			// Only count failures the client did not cause by going away
+			p.traffic.record(node, time.Since(sent), true)
+		}
+		if cause := deadlines.cause(); cause != nil {
+			p.logTimeout(cause, node, limits)
+			p.writeError(w, r, cause)
+			return
+		}
 		p.logger.Debug("❌ Proxy request failed: %v", err)
-		http.Error(w, err.Error(), http.StatusBadGateway)
+		p.writeError(w, r, newProxyError(http.StatusBadGateway, CodeUpstreamError, "failed to reach node %s", node).wrap(err))
 		return
 	}
 	defer resp.Body.Close()
+	deadlines.headersReceived()
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		upstreamSpan.SetAttr("http.response.status_code", resp.StatusCode)
+		upstreamSpan.End()
+		p.handleUpgrade(w, r, resp, node, deadlines)
+		return
+	}
+	ttfb := time.Since(sent)
+	p.traffic.record(node, ttfb, resp.StatusCode >= http.StatusInternalServerError)
+	if resp.StatusCode < http.StatusInternalServerError {
+		p.latency.recordTTFB(node, ttfb)
+	}
+	upstreamSpan.SetAttr("http.response.status_code", resp.StatusCode)
+	upstreamSpan.End()
+
+	_, streamSpan := p.tracer.Start(r.Context(), "proxy.stream", spanKindInternal)
+	var streamed int64
+	streamStart := time.Now()
+	defer func() {
+		p.latency.recordThroughput(node, streamed, time.Since(streamStart))
+		streamSpan.SetAttr("c3.response_bytes", streamed)
+		streamSpan.End()
+	}()
+	upstreamBody := &idleReader{body: resp.Body, deadlines: deadlines}
 
+	removeHopHeaders(resp.Header)
+	if p.cors.Enabled {
+		removeCORSHeaders(resp.Header)
+	}
+	p.setRoutingHeaders(resp.Header, r, apiKey, node, queued, ttfb)
+	applyHeaderRules(resp.Header, p.rewrites.rulesFor(info.Tag, true), rewriteVars(r, node))
 	copyHeader(w.Header(), resp.Header)
 	w.WriteHeader(resp.StatusCode)
 
 	if resp.StatusCode != http.StatusOK {
-		p.logger.Debug("⚠️  Proxy returned non-200 status: %d for %s %s", resp.StatusCode, r.Method, targetURL)
+		p.logger.Debug("⚠️  Proxy returned non-200 status: %d for %s %s", resp.StatusCode, r.Method, resp.Request.URL)
 	}
 
 	if f, ok := w.(http.Flusher); ok {
@@ -65,19 +147,29 @@ func (p *ProxyServer) HandleProxyRequest(w http.ResponseWriter, r *http.Request,
 		go func() {
 			buf := make([]byte, 1024)
 			for {
-				n, err := resp.Body.Read(buf)
+				n, err := upstreamBody.Read(buf)
 				if n > 0 {
 					if _, writeErr := w.Write(buf[:n]); writeErr != nil {
 						p.logger.Debug("❌ Error writing response: %v", writeErr)
+						streamSpan.RecordError(writeErr)
+						info.Truncated = true
 						break
 					}
+					streamed += int64(n)
 					f.Flush()
 				}
 				if err == io.EOF {
 					break
 				}
 				if err != nil {
-					p.logger.Debug("❌ Error reading from upstream: %v", err)
+					info.Truncated = true
+					if cause := deadlines.cause(); cause != nil {
+						p.logTimeout(cause, node, limits)
+						streamSpan.RecordError(cause)
+					} else {
+						p.logger.Debug("❌ Error reading from upstream: %v", err)
+						streamSpan.RecordError(err)
+					}
 					break
 				}
 			}
@@ -85,16 +177,79 @@ func (p *ProxyServer) HandleProxyRequest(w http.ResponseWriter, r *http.Request,
 		}()
 		<-done
 	} else {
-		if _, err := io.Copy(w, resp.Body); err != nil {
-			p.logger.Debug("❌ Error copying response: %v", err)
+		if streamed, err = io.Copy(w, upstreamBody); err != nil {
+			info.Truncated = true
+			if cause := deadlines.cause(); cause != nil {
+				p.logTimeout(cause, node, limits)
+				streamSpan.RecordError(cause)
+			} else {
+				p.logger.Debug("❌ Error copying response: %v", err)
+				streamSpan.RecordError(err)
+			}
 		}
 	}
 }
 
+// newUpstreamRequest builds the request sent to a node for a client request
+func (p *ProxyServer) newUpstreamRequest(ctx context.Context, r *http.Request, node, apiKey string, body io.Reader) (*http.Request, error) {
+	targetURL := fmt.Sprintf("https://%s%s", node, r.URL.Path)
+	if r.URL.RawQuery != "" {
+		targetURL += "?" + r.URL.RawQuery
+	}
+
+	proxyReq, err := http.NewRequestWithContext(ctx, r.Method, targetURL, body)
+	if err != nil {
+		return nil, err
+	}
+	proxyReq.ContentLength = r.ContentLength
+
+	copyHeader(proxyReq.Header, r.Header)
+	p.prepareUpstreamRequest(proxyReq, r, node, apiKey)
+	applyHeaderRules(proxyReq.Header, p.rewrites.rulesFor(getRequestInfo(r).Tag, false), rewriteVars(r, node))
+	return proxyReq, nil
+}
+
+// authenticate extracts the API key and checks its format, rejection status
+// and rate limit
+func (p *ProxyServer) authenticate(w http.ResponseWriter, r *http.Request) (string, error) {
+	apiKey := r.Header.Get("X-C3-API-KEY")
+	if apiKey == "" {
+		auth := r.Header.Get("Authorization")
+		if auth != "" && len(auth) > 7 && auth[:7] == "Bearer " {
+			apiKey = auth[7:]
+		}
+	}
+
+	if apiKey == "" {
+		return "", newProxyError(http.StatusUnauthorized, CodeMissingAPIKey,
+			"Missing API key (use X-C3-API-KEY header or Authorization Bearer)")
+	}
+
+	if err := p.validateAPIKey(apiKey); err != nil {
+		return "", err
+	}
+
+	if err := p.checkRateLimit(w, apiKey); err != nil {
+		return "", err
+	}
+	return apiKey, nil
+}
+
 // ProxyHandler handles all incoming HTTP requests
 func (p *ProxyServer) ProxyHandler(w http.ResponseWriter, r *http.Request) {
 	p.logger.Debug("🌐 Incoming request: %s %s", r.Method, r.URL.Path)
 
+	info := &requestInfo{ID: newRequestID(r), Start: time.Now()}
+	r = withRequestInfo(r, info)
+
+	if p.cors.Enabled {
+		if isPreflight(r) {
+			p.handlePreflight(w, r)
+			return
+		}
+		p.applyCORS(w, r)
+	}
+
 	if r.URL.Path == "/" && r.Method == "GET" {
 		p.logger.Debug("💚 Health check request - returning healthy status")
 		w.Header().Set("Content-Type", "application/json")
@@ -105,63 +260,134 @@ func (p *ProxyServer) ProxyHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	apiKey := r.Header.Get("X-C3-API-KEY")
-	if apiKey == "" {
-		auth := r.Header.Get("Authorization")
-		if auth != "" && len(auth) > 7 && auth[:7] == "Bearer " {
-			apiKey = auth[7:]
-		}
+	ctx, span := p.tracer.Start(extractTraceContext(r.Context(), r.Header), "proxy.request", spanKindServer)
+	defer span.End()
+	r = r.WithContext(ctx)
+	if span.recording() {
+		sw := &statusWriter{ResponseWriter: w}
+		w = sw
+		defer span.endRequest(sw)
 	}
+	span.SetAttr("http.request.method", r.Method)
+	span.SetAttr("url.path", r.URL.Path)
+	span.SetAttr("c3.request_id", info.ID)
 
-	if apiKey == "" {
-		http.Error(w, "Missing API key (use X-C3-API-KEY header or Authorization Bearer)", http.StatusUnauthorized)
+	_, authSpan := p.tracer.Start(ctx, "proxy.authenticate", spanKindInternal)
+	apiKey, err := p.authenticate(w, r)
+	authSpan.RecordError(err)
+	authSpan.End()
+	if err != nil {
+		p.writeError(w, r, err)
 		return
 	}
 
 	// Update last access time for this API key
 	p.updateLastAccess(apiKey)
 
-	p.logger.Debug("📝 Request from API key %s...: %s %s", apiKey[:8], r.Method, r.URL.Path)
+	p.logger.Debug("📝 Request from API key %s...: %s %s", maskKey(apiKey), r.Method, r.URL.Path)
+
+	workloadsCtx, workloadsSpan := p.tracer.Start(ctx, "proxy.workloads", spanKindInternal)
+	workloadsSpan.SetAttr("c3.cache_hit", true)
+	workloads, err := p.getWorkloads(workloadsCtx, apiKey)
+	workloadsSpan.RecordError(err)
+	workloadsSpan.End()
 
 	if r.URL.Path == "/workloads" {
-		workloads, err := p.getWorkloads(apiKey)
 		if err != nil {
 			p.logger.Debug("❌ Error fetching workloads: %v", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			p.writeError(w, r, err)
 			return
 		}
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(workloads)
+		json.NewEncoder(w).Encode(p.workloadViews(workloads))
 		return
 	}
 
-	if _, err := p.getWorkloads(apiKey); err != nil {
+	if err != nil {
 		p.logger.Debug("❌ Error refreshing workloads: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		p.writeError(w, r, err)
 		return
 	}
 
+	if route := p.routes.match(r); route != nil {
+		// Named routes translate public paths into the built-in /tags and /{index} routes
+		target := route.target(r.URL.Path)
+		p.logger.Debug("🛣️  Route %s maps %s%s to %s", route.Name, r.Host, r.URL.Path, target)
+		info.Route = route.Name
+		span.SetAttr("c3.route", route.Name)
+		r.URL.Path = target
+	}
+
 	pathParts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 3)
 	if len(pathParts) < 1 {
-		http.Error(w, "Invalid path. Use /tags/{tag} or /{index} to access workloads", http.StatusBadRequest)
+		p.writeError(w, r, newProxyError(http.StatusBadRequest, CodeInvalidPath,
+			"Invalid path. Use /tags/{tag} or /{index} to access workloads"))
+		return
+	}
+
+	if pathParts[0] == "broadcast" {
+		p.handleBroadcast(w, r, apiKey)
 		return
 	}
 
 	var node string
-	var err error
 
-	if pathParts[0] == "tags" {
+	if selector := p.tagSelector(r); selector != "" && info.Route == "" && !namesRoutingTarget(pathParts[0]) {
+		// The selector header routes the whole path, so clients can keep their
+		// usual base URL. Paths that name a tag, node or index keep their target.
+		info.Tag = selector
+		if p.serveCached(w, r, apiKey) {
+			return
+		}
+		info.Balancer = p.balancer
+		node, err = p.selectNodeForTag(r, apiKey, selector)
+		if err != nil {
+			p.logger.Debug("❌ No nodes found for tag selector %s: %v", selector, err)
+			p.writeError(w, r, err)
+			return
+		}
+	} else if pathParts[0] == "tags" {
 		if len(pathParts) < 2 {
-			http.Error(w, "Missing tag. Use /tags/{tag}", http.StatusBadRequest)
+			p.writeError(w, r, newProxyError(http.StatusBadRequest, CodeInvalidPath, "Missing tag. Use /tags/{tag}"))
 			return
 		}
 		tag := pathParts[1]
-		node, err = p.GetLeastBusyNode(apiKey, tag)
+		info.Tag = tag
+		if len(pathParts) > 2 {
+			r.URL.Path = "/" + pathParts[2]
+		} else {
+			r.URL.Path = "/"
+		}
+		if p.serveCached(w, r, apiKey) {
+			return
+		}
+		info.Balancer = p.balancer
+		node, err = p.selectNodeForTag(r, apiKey, tag)
 		if err != nil {
 			p.logger.Debug("❌ No nodes found for tag %s: %v", tag, err)
-			http.Error(w, err.Error(), http.StatusNotFound)
+			p.writeError(w, r, err)
+			return
+		}
+	} else if pathParts[0] == "nodes" {
+		if len(pathParts) < 2 || pathParts[1] == "" {
+			p.writeError(w, r, newProxyError(http.StatusBadRequest, CodeInvalidPath, "Missing node. Use /nodes/{node or workload}"))
+			return
+		}
+		id := pathParts[1]
+		workload, found := findWorkload(p.runningWorkloads(workloads), id)
+		if !found {
+			p.writeError(w, r, newProxyError(http.StatusNotFound, CodeUnknownWorkload,
+				"No running workload or node named %s", id))
+			return
+		}
+		if err := p.checkWorkloadRoutable(apiKey, workload); err != nil {
+			p.writeError(w, r, err)
 			return
 		}
+		node = workload.Node
+		info.Balancer = "node"
+		p.logger.Debug("🎯 Selected node %s for workload %s", node, workload.Workload)
+
 		if len(pathParts) > 2 {
 			r.URL.Path = "/" + pathParts[2]
 		} else {
@@ -170,30 +396,17 @@ func (p *ProxyServer) ProxyHandler(w http.ResponseWriter, r *http.Request) {
 	} else {
 		index, err := strconv.Atoi(pathParts[0])
 		if err != nil {
-			http.Error(w, "Invalid workload index. Must be a number", http.StatusBadRequest)
+			p.writeError(w, r, newProxyError(http.StatusBadRequest, CodeInvalidPath, "Invalid workload index. Must be a number"))
 			return
 		}
 
-		// Force refresh workloads to ensure we have the latest data
-		workloads, err := p.forceRefreshWorkloads(apiKey)
-		if err != nil {
-			p.logger.Error("❌ Error refreshing workloads: %v", err)
-			http.Error(w, fmt.Sprintf("Failed to refresh workloads: %v", err), http.StatusInternalServerError)
-			return
-		}
-
-		// Create a filtered list of only running workloads
-		runningWorkloads := make([]Workload, 0)
-		for _, w := range workloads {
-			if w.Running && w.Status == "running" {
-				runningWorkloads = append(runningWorkloads, w)
-			}
-		}
+		// Index routing uses the same cached workloads as tag routing
+		runningWorkloads := p.runningWorkloads(workloads)
 
 		// Check if we have any running workloads
 		if len(runningWorkloads) == 0 {
-			p.logger.Error("⚠️ No running workloads found for API key %s...", apiKey[:8])
-			http.Error(w, "No running workloads found", http.StatusNotFound)
+			p.logger.Error("⚠️ No running workloads found for API key %s...", maskKey(apiKey))
+			p.writeError(w, r, newProxyError(http.StatusServiceUnavailable, CodeNoHealthyNodes, "No running workloads found"))
 			return
 		}
 
@@ -201,12 +414,17 @@ func (p *ProxyServer) ProxyHandler(w http.ResponseWriter, r *http.Request) {
 		if index < 0 || index >= len(runningWorkloads) {
 			p.logger.Error("⚠️ Invalid workload index %d (valid range: 0-%d)",
 				index, len(runningWorkloads)-1)
-			http.Error(w, fmt.Sprintf("Workload index %d out of range (0-%d)",
-				index, len(runningWorkloads)-1), http.StatusNotFound)
+			p.writeError(w, r, newProxyError(http.StatusNotFound, CodeInvalidIndex,
+				"Workload index %d out of range (0-%d)", index, len(runningWorkloads)-1))
 			return
 		}
 
+		if err := p.checkWorkloadRoutable(apiKey, runningWorkloads[index]); err != nil {
+			p.writeError(w, r, err)
+			return
+		}
 		node = runningWorkloads[index].Node
+		info.Balancer = "index"
 		p.logger.Debug("🔢 Selected node %s by index %d", node, index)
 
 		if len(pathParts) > 1 {
@@ -216,10 +434,23 @@ func (p *ProxyServer) ProxyHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	span.SetAttr("c3.tag", info.Tag)
+	span.SetAttr("c3.node", node)
+
 	done := make(chan bool)
 	go func() {
-		p.HandleProxyRequest(w, r, node, apiKey)
+		p.handleCacheable(w, r, node, apiKey)
 		close(done)
 	}()
 	<-done
 }
+
+// namesRoutingTarget reports whether a path's first segment selects the
+// node itself: /tags/{tag}, /nodes/{node} or /{index}
+func namesRoutingTarget(segment string) bool {
+	if segment == "tags" || segment == "nodes" {
+		return true
+	}
+	_, err := strconv.Atoi(segment)
+	return err == nil
+}
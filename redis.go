@@ -0,0 +1,535 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redisPrefix namespaces every key the proxy writes
+const redisPrefix = "c3proxy:"
+
+// replicaTTL is how long a replica counts as alive after its last heartbeat
+const replicaTTL = 30 * time.Second
+
+const (
+	peerCacheTTL     = time.Second     // peer in-flight counts are reused this long
+	liveCacheTTL     = 5 * time.Second // replica liveness is reused this long
+	peerCacheMaxIdle = time.Minute     // unread peer counts are dropped after this
+)
+
+// redisError is an error reply from the server
+type redisError string
+
+func (e redisError) Error() string { return string(e) }
+
+// redisConn is a single connection speaking RESP
+type redisConn struct {
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+// redisClient is a minimal pooled client for Redis-compatible servers. The
+// dial function is swappable so the backend can run against an in-process fake.
+type redisClient struct {
+	dial    func() (net.Conn, error)
+	setup   [][]string // commands run on every new connection (AUTH, SELECT)
+	pool    chan *redisConn
+	timeout time.Duration
+}
+
+// newRedisClient parses redis://[user:password@]host:port[/db] (or rediss:// for TLS)
+func newRedisClient(rawURL string) (*redisClient, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL: %v", err)
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, fmt.Errorf("invalid REDIS_URL scheme %q", u.Scheme)
+	}
+
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	timeout := 2 * time.Second
+
+	client := &redisClient{pool: make(chan *redisConn, 16), timeout: timeout}
+	client.dial = func() (net.Conn, error) {
+		dialer := &net.Dialer{Timeout: timeout}
+		if u.Scheme == "rediss" {
+			return tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: u.Hostname()})
+		}
+		return dialer.Dial("tcp", addr)
+	}
+
+	if u.User != nil {
+		if password, ok := u.User.Password(); ok {
+			if username := u.User.Username(); username != "" {
+				client.setup = append(client.setup, []string{"AUTH", username, password})
+			} else {
+				client.setup = append(client.setup, []string{"AUTH", password})
+			}
+		}
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if _, err := strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid REDIS_URL database %q", db)
+		}
+		client.setup = append(client.setup, []string{"SELECT", db})
+	}
+	return client, nil
+}
+
+func (c *redisClient) get() (*redisConn, error) {
+	select {
+	case conn := <-c.pool:
+		return conn, nil
+	default:
+	}
+
+	netConn, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{conn: netConn, rd: bufio.NewReader(netConn)}
+	for _, args := range c.setup {
+		if _, err := conn.do(c.timeout, args...); err != nil {
+			netConn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (c *redisClient) put(conn *redisConn) {
+	select {
+	case c.pool <- conn:
+	default:
+		conn.conn.Close()
+	}
+}
+
+// Do sends a command and returns its reply. Error replies are returned as redisError.
+func (c *redisClient) Do(args ...string) (interface{}, error) {
+	conn, err := c.get()
+	if err != nil {
+		return nil, err
+	}
+	reply, err := conn.do(c.timeout, args...)
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		// The connection state is unknown after an I/O error
+		conn.conn.Close()
+		return nil, err
+	}
+	c.put(conn)
+	return reply, err
+}
+
+func (c *redisClient) Close() {
+	for {
+		select {
+		case conn := <-c.pool:
+			conn.conn.Close()
+		default:
+			return
+		}
+	}
+}
+
+func (conn *redisConn) do(timeout time.Duration, args ...string) (interface{}, error) {
+	conn.conn.SetDeadline(time.Now().Add(timeout))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(conn.conn, b.String()); err != nil {
+		return nil, err
+	}
+	return conn.readReply()
+}
+
+func (conn *redisConn) readLine() (string, error) {
+	line, err := conn.rd.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("malformed RESP line %q", line)
+	}
+	return line[:len(line)-2], nil
+}
+
+func (conn *redisConn) readReply() (interface{}, error) {
+	line, err := conn.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if line == "" {
+		return nil, fmt.Errorf("empty RESP reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(conn.rd, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil || count < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, count)
+		for i := range items {
+			if items[i], err = conn.readReply(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("unexpected RESP reply %q", line)
+	}
+}
+
+// inFlightField identifies a published in-flight count
+type inFlightField struct {
+	apiKey string
+	node   string
+}
+
+// redisState shares proxy state through a Redis-compatible server. In-flight
+// counts are written by a background publisher so requests never wait on Redis
+// to record them.
+type redisState struct {
+	client  *redisClient
+	replica string
+	logger  *Logger
+	stop    chan struct{}
+
+	pubMu     sync.Mutex
+	pending   map[inFlightField]int // latest unpublished count per node
+	wake      chan struct{}
+	published chan struct{} // closed once the publisher has exited
+
+	cacheMu   sync.Mutex
+	peers     map[string]*peerCounts // by API key
+	replicas  map[string]replicaLiveness
+	lastPurge time.Time
+}
+
+// peerCounts caches the in-flight counts of other replicas for an API key
+type peerCounts struct {
+	counts     map[string]int
+	fetched    time.Time
+	read       time.Time
+	refreshing bool
+}
+
+type replicaLiveness struct {
+	alive   bool
+	checked time.Time
+}
+
+func newRedisState(rawURL, replica string, logger *Logger) (*redisState, error) {
+	client, err := newRedisClient(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return startRedisState(client, replica, logger)
+}
+
+// startRedisState verifies the connection and starts the replica heartbeat
+func startRedisState(client *redisClient, replica string, logger *Logger) (*redisState, error) {
+	if _, err := client.Do("PING"); err != nil {
+		return nil, fmt.Errorf("failed to connect to Redis: %v", err)
+	}
+
+	state := &redisState{
+		client:    client,
+		replica:   replica,
+		logger:    logger,
+		stop:      make(chan struct{}),
+		pending:   make(map[inFlightField]int),
+		wake:      make(chan struct{}, 1),
+		published: make(chan struct{}),
+		peers:     make(map[string]*peerCounts),
+		replicas:  make(map[string]replicaLiveness),
+		lastPurge: time.Now(),
+	}
+	if err := state.heartbeat(); err != nil {
+		return nil, err
+	}
+
+	go state.publish()
+	go func() {
+		ticker := time.NewTicker(replicaTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := state.heartbeat(); err != nil {
+					logger.Warn("⚠️  Redis heartbeat failed: %v", err)
+				}
+				// Retry counts that failed to publish
+				state.signal()
+			case <-state.stop:
+				return
+			}
+		}
+	}()
+	return state, nil
+}
+
+func (s *redisState) heartbeat() error {
+	_, err := s.client.Do("SET", redisPrefix+"replica:"+s.replica, "1", "PX",
+		strconv.FormatInt(replicaTTL.Milliseconds(), 10))
+	return err
+}
+
+func inFlightKey(apiKey string) string {
+	return redisPrefix + "inflight:" + hashKey(apiKey)
+}
+
+// PublishInFlight queues this replica's count for the publisher. Only the
+// latest count per node is kept, so a burst of changes costs a single write
+// and an older count can never overwrite a newer one.
+func (s *redisState) PublishInFlight(apiKey, node string, count int) error {
+	s.pubMu.Lock()
+	s.pending[inFlightField{apiKey: apiKey, node: node}] = count
+	s.pubMu.Unlock()
+	s.signal()
+	return nil
+}
+
+// signal wakes the publisher without blocking
+func (s *redisState) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// publish writes queued counts until the state is closed, then flushes once more
+func (s *redisState) publish() {
+	defer close(s.published)
+	for {
+		select {
+		case <-s.wake:
+			s.flush()
+		case <-s.stop:
+			s.flush()
+			return
+		}
+	}
+}
+
+// flush writes every queued count. Counts that fail to write are queued again
+// unless a newer count for the node has arrived in the meantime.
+func (s *redisState) flush() {
+	s.pubMu.Lock()
+	batch := s.pending
+	s.pending = make(map[inFlightField]int)
+	s.pubMu.Unlock()
+
+	for field, count := range batch {
+		if err := s.writeInFlight(field.apiKey, field.node, count); err != nil {
+			s.logger.Debug("⚠️  Failed to publish in-flight count for node %s: %v", field.node, err)
+			s.pubMu.Lock()
+			if _, newer := s.pending[field]; !newer {
+				s.pending[field] = count
+			}
+			s.pubMu.Unlock()
+		}
+	}
+}
+
+// writeInFlight stores a count as a "<replica>|<node>" hash field
+func (s *redisState) writeInFlight(apiKey, node string, count int) error {
+	field := s.replica + "|" + node
+	if count <= 0 {
+		_, err := s.client.Do("HDEL", inFlightKey(apiKey), field)
+		return err
+	}
+	_, err := s.client.Do("HSET", inFlightKey(apiKey), field, strconv.Itoa(count))
+	return err
+}
+
+// PeerInFlight returns the counts published by other live replicas. Counts
+// are cached for peerCacheTTL; after that the cached counts are still returned
+// while a single background refresh runs, so only the first lookup for a key
+// (or one idle for longer than replicaTTL) waits on Redis. The returned map
+// is shared and must not be modified.
+func (s *redisState) PeerInFlight(apiKey string) (map[string]int, error) {
+	now := time.Now()
+	s.cacheMu.Lock()
+	if cached, exists := s.peers[apiKey]; exists && now.Sub(cached.fetched) < replicaTTL {
+		cached.read = now
+		if now.Sub(cached.fetched) >= peerCacheTTL && !cached.refreshing {
+			cached.refreshing = true
+			go func() {
+				if _, err := s.refreshPeers(apiKey); err != nil {
+					s.logger.Debug("⚠️  Failed to refresh peer in-flight counts: %v", err)
+				}
+			}()
+		}
+		counts := cached.counts
+		s.cacheMu.Unlock()
+		return counts, nil
+	}
+	s.cacheMu.Unlock()
+	return s.refreshPeers(apiKey)
+}
+
+// refreshPeers reads peer counts from Redis and caches them
+func (s *redisState) refreshPeers(apiKey string) (map[string]int, error) {
+	counts, err := s.readPeerInFlight(apiKey)
+
+	now := time.Now()
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+
+	if now.Sub(s.lastPurge) > peerCacheMaxIdle {
+		for key, cached := range s.peers {
+			if now.Sub(cached.read) > peerCacheMaxIdle && !cached.refreshing {
+				delete(s.peers, key)
+			}
+		}
+		for replica, liveness := range s.replicas {
+			if now.Sub(liveness.checked) > liveCacheTTL {
+				delete(s.replicas, replica)
+			}
+		}
+		s.lastPurge = now
+	}
+
+	if err != nil {
+		if cached, exists := s.peers[apiKey]; exists {
+			cached.refreshing = false
+		}
+		return nil, err
+	}
+	s.peers[apiKey] = &peerCounts{counts: counts, fetched: now, read: now}
+	return counts, nil
+}
+
+// replicaAlive reports whether a replica's heartbeat has not expired,
+// checking Redis at most once per liveCacheTTL
+func (s *redisState) replicaAlive(replica string) (bool, error) {
+	s.cacheMu.Lock()
+	liveness, exists := s.replicas[replica]
+	s.cacheMu.Unlock()
+	if exists && time.Since(liveness.checked) < liveCacheTTL {
+		return liveness.alive, nil
+	}
+
+	reply, err := s.client.Do("EXISTS", redisPrefix+"replica:"+replica)
+	if err != nil {
+		return false, err
+	}
+	liveness = replicaLiveness{alive: reply == int64(1), checked: time.Now()}
+	s.cacheMu.Lock()
+	s.replicas[replica] = liveness
+	s.cacheMu.Unlock()
+	return liveness.alive, nil
+}
+
+// readPeerInFlight sums the counts published by other replicas whose heartbeat
+// is still alive. Fields left behind by dead replicas are removed.
+func (s *redisState) readPeerInFlight(apiKey string) (map[string]int, error) {
+	reply, err := s.client.Do("HGETALL", inFlightKey(apiKey))
+	if err != nil {
+		return nil, err
+	}
+	items, _ := reply.([]interface{})
+
+	counts := make(map[string]int)
+	var dead []string
+	for i := 0; i+1 < len(items); i += 2 {
+		field, _ := items[i].(string)
+		value, _ := items[i+1].(string)
+		replica, node, found := strings.Cut(field, "|")
+		if !found || replica == s.replica {
+			continue
+		}
+
+		live, err := s.replicaAlive(replica)
+		if err != nil {
+			return nil, err
+		}
+		if !live {
+			dead = append(dead, field)
+			continue
+		}
+
+		count, _ := strconv.Atoi(value)
+		counts[node] += count
+	}
+
+	if len(dead) > 0 {
+		args := append([]string{"HDEL", inFlightKey(apiKey)}, dead...)
+		if _, err := s.client.Do(args...); err != nil {
+			s.logger.Debug("⚠️  Failed to remove in-flight counts of dead replicas: %v", err)
+		}
+	}
+	return counts, nil
+}
+
+func (s *redisState) GetAffinity(key string) (string, bool, error) {
+	reply, err := s.client.Do("GET", redisPrefix+"affinity:"+key)
+	if err != nil {
+		return "", false, err
+	}
+	node, ok := reply.(string)
+	return node, ok, nil
+}
+
+func (s *redisState) SetAffinity(key, node string, ttl time.Duration) error {
+	_, err := s.client.Do("SET", redisPrefix+"affinity:"+key, node, "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	return err
+}
+
+func (s *redisState) IncrementBucket(key string, window time.Duration) (int64, error) {
+	bucket := redisPrefix + "bucket:" + key
+	reply, err := s.client.Do("INCR", bucket)
+	if err != nil {
+		return 0, err
+	}
+	count, _ := reply.(int64)
+	if count == 1 {
+		if _, err := s.client.Do("PEXPIRE", bucket, strconv.FormatInt(window.Milliseconds(), 10)); err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+func (s *redisState) Close() error {
+	close(s.stop)
+	<-s.published
+	_, err := s.client.Do("DEL", redisPrefix+"replica:"+s.replica)
+	s.client.Close()
+	return err
+}
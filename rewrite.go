@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// HeaderRule is a single declarative header rewrite
+type HeaderRule struct {
+	Action string `json:"action"` // add, set, remove or rename
+	Name   string `json:"name"`
+	Value  string `json:"value,omitempty"` // supports ${tag}, ${node}, ${request_id}, ...
+	To     string `json:"to,omitempty"`    // target header name for rename
+}
+
+// HeaderRuleSet groups rules for both directions of a proxied request
+type HeaderRuleSet struct {
+	Request  []HeaderRule `json:"request,omitempty"`
+	Response []HeaderRule `json:"response,omitempty"`
+}
+
+// HeaderRewriteConfig holds the global rules and per-tag overrides. Global
+// rules run first, followed by any rules for the request's tag.
+type HeaderRewriteConfig struct {
+	Global HeaderRuleSet            `json:"global"`
+	Tags   map[string]HeaderRuleSet `json:"tags,omitempty"`
+}
+
+// loadHeaderRewriteConfig reads HEADER_RULES (or HEADER_RULES_FILE). The legacy
+// STRIP_ORIGIN=true setting is translated into a global remove rule.
+func loadHeaderRewriteConfig(logger *Logger) (*HeaderRewriteConfig, error) {
+	cfg := &HeaderRewriteConfig{}
+	found, err := loadJSONEnv("HEADER_RULES", cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if os.Getenv("STRIP_ORIGIN") == "true" {
+		logger.Info("🧹 STRIP_ORIGIN is set, removing Origin header from proxied requests")
+		cfg.Global.Request = append([]HeaderRule{{Action: "remove", Name: "Origin"}}, cfg.Global.Request...)
+	}
+
+	sets := []HeaderRuleSet{cfg.Global}
+	for _, set := range cfg.Tags {
+		sets = append(sets, set)
+	}
+	for _, set := range sets {
+		for _, rule := range append(append([]HeaderRule{}, set.Request...), set.Response...) {
+			if err := rule.validate(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if found {
+		logger.Info("📝 Loaded header rewrite rules (%d global, %d tags)",
+			len(cfg.Global.Request)+len(cfg.Global.Response), len(cfg.Tags))
+	}
+	return cfg, nil
+}
+
+func (r HeaderRule) validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("header rule %q is missing a name", r.Action)
+	}
+	switch strings.ToLower(r.Action) {
+	case "add", "set", "remove":
+	case "rename":
+		if r.To == "" {
+			return fmt.Errorf("rename rule for %s is missing a target name", r.Name)
+		}
+	default:
+		return fmt.Errorf("unknown header rule action %q for %s", r.Action, r.Name)
+	}
+	return nil
+}
+
+// rulesFor returns the rules that apply to a tag in the given direction
+func (c *HeaderRewriteConfig) rulesFor(tag string, response bool) []HeaderRule {
+	if c == nil {
+		return nil
+	}
+	pick := func(set HeaderRuleSet) []HeaderRule {
+		if response {
+			return set.Response
+		}
+		return set.Request
+	}
+
+	rules := pick(c.Global)
+	if set, ok := c.Tags[tag]; ok && tag != "" {
+		rules = append(append([]HeaderRule{}, rules...), pick(set)...)
+	}
+	return rules
+}
+
+// applyHeaderRules runs rules against h, expanding ${...} templates in values
+func applyHeaderRules(h http.Header, rules []HeaderRule, vars map[string]string) {
+	expand := func(value string) string { return expandTemplate(value, vars) }
+
+	for _, rule := range rules {
+		switch strings.ToLower(rule.Action) {
+		case "add":
+			h.Add(rule.Name, expand(rule.Value))
+		case "set":
+			h.Set(rule.Name, expand(rule.Value))
+		case "remove":
+			h.Del(rule.Name)
+		case "rename":
+			if values := h.Values(rule.Name); len(values) > 0 {
+				values = append([]string{}, values...)
+				h.Del(rule.Name)
+				for _, v := range values {
+					h.Add(rule.To, v)
+				}
+			}
+		}
+	}
+}
+
+// expandTemplate replaces ${name} with vars[name] and $$ with a literal $.
+// Any other $ is kept as is, so values like "price: $5" pass through.
+func expandTemplate(value string, vars map[string]string) string {
+	if !strings.Contains(value, "$") {
+		return value
+	}
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(value, '$')
+		if i < 0 || i == len(value)-1 {
+			b.WriteString(value)
+			return b.String()
+		}
+		b.WriteString(value[:i])
+		switch value[i+1] {
+		case '$':
+			b.WriteByte('$')
+			value = value[i+2:]
+			continue
+		case '{':
+			if end := strings.IndexByte(value[i+2:], '}'); end >= 0 {
+				b.WriteString(vars[value[i+2:i+2+end]])
+				value = value[i+3+end:]
+				continue
+			}
+		}
+		b.WriteByte('$')
+		value = value[i+1:]
+	}
+}
+
+// rewriteVars builds the template variables available to header rules
+func rewriteVars(r *http.Request, node string) map[string]string {
+	info := getRequestInfo(r)
+	return map[string]string{
+		"tag":        info.Tag,
+		"route":      info.Route,
+		"node":       node,
+		"request_id": info.ID,
+		"method":     r.Method,
+		"path":       r.URL.Path,
+		"host":       r.Host,
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExpandTemplate(t *testing.T) {
+	vars := map[string]string{"tag": "llama", "node": "node-a"}
+	tests := map[string]string{
+		"plain":                "plain",
+		"${tag}@${node}":       "llama@node-a",
+		"price: $5":            "price: $5",
+		"$${tag}":              "${tag}",
+		"$$${tag}":             "$llama",
+		"${missing}-x":         "-x",
+		"${unterminated":       "${unterminated",
+		"trailing $":           "trailing $",
+		"${tag}${tag}/${node}": "llamallama/node-a",
+	}
+	for value, want := range tests {
+		if got := expandTemplate(value, vars); got != want {
+			t.Errorf("expandTemplate(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
+
+func TestApplyHeaderRules(t *testing.T) {
+	h := http.Header{"X-Old": {"a", "b"}, "X-Drop": {"1"}, "X-Set": {"old"}}
+	applyHeaderRules(h, []HeaderRule{
+		{Action: "add", Name: "X-Added", Value: "${tag}"},
+		{Action: "ADD", Name: "X-Added", Value: "second"},
+		{Action: "set", Name: "X-Set", Value: "new"},
+		{Action: "remove", Name: "x-drop"},
+		{Action: "rename", Name: "X-Old", To: "X-New"},
+		{Action: "rename", Name: "X-Absent", To: "X-Still-Absent"},
+	}, map[string]string{"tag": "llama"})
+
+	want := http.Header{"X-Added": {"llama", "second"}, "X-Set": {"new"}, "X-New": {"a", "b"}}
+	if len(h) != len(want) {
+		t.Fatalf("headers = %v, want %v", h, want)
+	}
+	for name, values := range want {
+		if got := h.Values(name); len(got) != len(values) || got[0] != values[0] || got[len(got)-1] != values[len(values)-1] {
+			t.Errorf("%s = %v, want %v", name, got, values)
+		}
+	}
+}
+
+func TestHeaderRuleConfig(t *testing.T) {
+	t.Setenv("HEADER_RULES", `{
+		"global": {"request": [{"action": "set", "name": "X-Global", "value": "1"}]},
+		"tags": {"llama": {"request": [{"action": "set", "name": "X-Global", "value": "llama"}],
+		                   "response": [{"action": "remove", "name": "Server"}]}}
+	}`)
+	t.Setenv("STRIP_ORIGIN", "true")
+	cfg, err := loadHeaderRewriteConfig(NewLogger("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Global rules run first, with the legacy Origin rule ahead of them
+	rules := cfg.rulesFor("llama", false)
+	if len(rules) != 3 || rules[0].Name != "Origin" || rules[2].Value != "llama" {
+		t.Errorf("request rules for llama = %+v", rules)
+	}
+	if rules := cfg.rulesFor("bge", false); len(rules) != 2 {
+		t.Errorf("request rules for an unconfigured tag = %+v, want only the global ones", rules)
+	}
+	if rules := cfg.rulesFor("llama", true); len(rules) != 1 || rules[0].Name != "Server" {
+		t.Errorf("response rules for llama = %+v", rules)
+	}
+
+	for name, rules := range map[string]string{
+		"missing name":   `{"global": {"request": [{"action": "set"}]}}`,
+		"unknown action": `{"global": {"request": [{"action": "append", "name": "X"}]}}`,
+		"rename target":  `{"tags": {"llama": {"response": [{"action": "rename", "name": "X"}]}}}`,
+	} {
+		t.Setenv("HEADER_RULES", rules)
+		if _, err := loadHeaderRewriteConfig(NewLogger("test")); err == nil {
+			t.Errorf("%s: rules loaded", name)
+		}
+	}
+}
+
+func TestHeaderRulesApplyToProxiedRequests(t *testing.T) {
+	var received http.Header
+	node := newTestNode(t, func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+		w.Header().Set("Server", "node")
+		w.Header().Set("X-Node-Secret", "s3cret")
+	})
+	p := newTestProxy(t, map[string]string{"HEADER_RULES": `{
+		"global": {"request":  [{"action": "set", "name": "X-Route", "value": "${tag} ${method} ${path}"}],
+		           "response": [{"action": "rename", "name": "Server", "to": "X-Upstream-Server"},
+		                        {"action": "remove", "name": "X-Node-Secret"}]}
+	}`})
+	seedWorkloads(p, testKey, testWorkload(node, "w-1", "llama"))
+
+	w := doRequest(p, httptest.NewRequest(http.MethodPost, "/tags/llama/v1/chat", nil))
+	if got := received.Get("X-Route"); got != "llama POST /v1/chat" {
+		t.Errorf("node received X-Route %q", got)
+	}
+	if w.Header().Get("X-Upstream-Server") != "node" || w.Header().Get("Server") != "" || w.Header().Get("X-Node-Secret") != "" {
+		t.Errorf("response headers = %v", w.Header())
+	}
+}
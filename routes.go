@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Route maps a public path prefix and/or hostname to a tag, workload index or
+// node, so clients do not need to hardcode /tags/{tag} or /{index} paths
+type Route struct {
+	Name   string `json:"-"`
+	Host   string `json:"host,omitempty"`
+	Prefix string `json:"prefix,omitempty"`
+	Tag    string `json:"tag,omitempty"`   // tag or tag selector
+	Index  *int   `json:"index,omitempty"` // workload index
+	Node   string `json:"node,omitempty"`  // node name or workload ID
+	// Rewrite replaces the matched prefix in the path sent to the node;
+	// StripPrefix removes it. By default the path is forwarded unchanged.
+	Rewrite     string `json:"rewrite,omitempty"`
+	StripPrefix bool   `json:"strip_prefix,omitempty"`
+}
+
+// RouteTable holds named routes ordered from most to least specific
+type RouteTable struct {
+	routes []*Route
+}
+
+// loadRouteTable reads ROUTES (or ROUTES_FILE), a JSON object of routes keyed by name
+func loadRouteTable(logger *Logger) (*RouteTable, error) {
+	var named map[string]*Route
+	found, err := loadJSONEnv("ROUTES", &named)
+	if err != nil {
+		return nil, err
+	}
+
+	table := &RouteTable{}
+	for name, route := range named {
+		route.Name = name
+		route.Host = strings.ToLower(route.Host)
+		if route.Prefix != "" && route.Prefix != "/" {
+			route.Prefix = "/" + strings.Trim(route.Prefix, "/")
+		}
+		if err := route.validate(); err != nil {
+			return nil, err
+		}
+		table.routes = append(table.routes, route)
+	}
+
+	// Host routes win over host-less ones, then longer prefixes over shorter
+	sort.Slice(table.routes, func(i, j int) bool {
+		a, b := table.routes[i], table.routes[j]
+		if (a.Host != "") != (b.Host != "") {
+			return a.Host != ""
+		}
+		if len(a.Prefix) != len(b.Prefix) {
+			return len(a.Prefix) > len(b.Prefix)
+		}
+		return a.Name < b.Name
+	})
+
+	if found {
+		logger.Info("🛣️  Loaded %d named routes", len(table.routes))
+	}
+	return table, nil
+}
+
+func (r *Route) validate() error {
+	if r.Host == "" && r.Prefix == "" {
+		return fmt.Errorf("route %s needs a host or prefix", r.Name)
+	}
+	if r.Prefix != "" && !strings.HasPrefix(r.Prefix, "/") {
+		return fmt.Errorf("route %s prefix must start with /", r.Name)
+	}
+	targets := 0
+	for _, set := range []bool{r.Tag != "", r.Index != nil, r.Node != ""} {
+		if set {
+			targets++
+		}
+	}
+	if targets != 1 {
+		return fmt.Errorf("route %s needs exactly one of tag, index or node", r.Name)
+	}
+	// Plain tags follow the selector rules too, so a bad tag fails at load
+	// time rather than as a 404 on every request
+	if r.Tag != "" {
+		if _, err := parseTagSelector(r.Tag); err != nil {
+			return fmt.Errorf("route %s: %v", r.Name, err)
+		}
+	}
+	if r.Index != nil && *r.Index < 0 {
+		return fmt.Errorf("route %s has a negative index", r.Name)
+	}
+	return nil
+}
+
+// match returns the most specific route for the request's host and path
+func (t *RouteTable) match(r *http.Request) *Route {
+	if t == nil || len(t.routes) == 0 {
+		return nil
+	}
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+
+	for _, route := range t.routes {
+		if route.Host != "" && route.Host != host {
+			continue
+		}
+		if route.Prefix != "" && !hasPathPrefix(r.URL.Path, route.Prefix) {
+			continue
+		}
+		return route
+	}
+	return nil
+}
+
+// hasPathPrefix reports whether path is prefix or lies below it, so /chat
+// matches /chat and /chat/x but not /chatter
+func hasPathPrefix(path, prefix string) bool {
+	if prefix == "/" {
+		return true
+	}
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// target returns the built-in route path equivalent to r for a request path
+func (r *Route) target(path string) string {
+	switch {
+	case r.Rewrite != "":
+		path = joinPath(r.Rewrite, strings.TrimPrefix(path, r.Prefix))
+	case r.StripPrefix:
+		path = joinPath("/", strings.TrimPrefix(path, r.Prefix))
+	}
+
+	if r.Index != nil {
+		return joinPath("/"+strconv.Itoa(*r.Index), path)
+	}
+	if r.Node != "" {
+		return joinPath("/nodes/"+r.Node, path)
+	}
+	return joinPath("/tags/"+r.Tag, path)
+}
+
+// joinPath joins two path segments with exactly one slash between them
+func joinPath(base, rest string) string {
+	if rest == "" || rest == "/" {
+		if base == "" {
+			return "/"
+		}
+		return base
+	}
+	return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(rest, "/")
+}
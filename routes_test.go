@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func loadTestRoutes(t *testing.T, routes string) (*RouteTable, error) {
+	t.Helper()
+	t.Setenv("ROUTES", routes)
+	return loadRouteTable(NewLogger("test"))
+}
+
+func TestRouteMatching(t *testing.T) {
+	table, err := loadTestRoutes(t, `{
+		"chat":      {"prefix": "/chat", "tag": "llama"},
+		"chat-v2":   {"prefix": "/chat/v2/", "tag": "llama+70b", "strip_prefix": true},
+		"embed":     {"prefix": "/embed", "index": 1, "rewrite": "/v1/embeddings"},
+		"internal":  {"host": "Internal.Example.com", "node": "node-a"},
+		"catch-all": {"prefix": "/", "tag": "default"}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		host, path string
+		wantRoute  string
+		wantTarget string
+	}{
+		{"proxy", "/chat", "chat", "/tags/llama/chat"},
+		{"proxy", "/chat/completions", "chat", "/tags/llama/chat/completions"},
+		{"proxy", "/chatter", "catch-all", "/tags/default/chatter"},
+		{"proxy", "/chat/v2/completions", "chat-v2", "/tags/llama+70b/completions"},
+		{"proxy", "/embed", "embed", "/1/v1/embeddings"},
+		{"proxy", "/embed/batch", "embed", "/1/v1/embeddings/batch"},
+		{"internal.example.com:8080", "/chat", "internal", "/nodes/node-a/chat"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		r.Host = tt.host
+		route := table.match(r)
+		if route == nil || route.Name != tt.wantRoute {
+			t.Errorf("%s%s matched %v, want %s", tt.host, tt.path, route, tt.wantRoute)
+			continue
+		}
+		if got := route.target(r.URL.Path); got != tt.wantTarget {
+			t.Errorf("%s%s target = %s, want %s", tt.host, tt.path, got, tt.wantTarget)
+		}
+	}
+}
+
+func TestRouteValidation(t *testing.T) {
+	tests := map[string]string{
+		"no host or prefix": `{"r": {"tag": "llama"}}`,
+		"two targets":       `{"r": {"prefix": "/a", "tag": "llama", "node": "node-a"}}`,
+		"no target":         `{"r": {"prefix": "/a"}}`,
+		"negative index":    `{"r": {"prefix": "/a", "index": -1}}`,
+		"slash in tag":      `{"r": {"prefix": "/a", "tag": "llama/70b"}}`,
+		"space in tag":      `{"r": {"prefix": "/a", "tag": "llama 70b"}}`,
+		"empty selector":    `{"r": {"prefix": "/a", "tag": "llama+"}}`,
+	}
+	for name, routes := range tests {
+		if _, err := loadTestRoutes(t, routes); err == nil {
+			t.Errorf("%s: route table loaded", name)
+		}
+	}
+}
+
+func TestRouteProxiesToTag(t *testing.T) {
+	node := newTestNode(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	})
+	p := newTestProxy(t, map[string]string{"ROUTES": `{"chat": {"prefix": "/chat", "tag": "llama", "rewrite": "/v1/chat"}}`})
+	seedWorkloads(p, testKey, testWorkload(node, "w-1", "llama"))
+
+	w := doRequest(p, httptest.NewRequest(http.MethodPost, "/chat/completions", strings.NewReader("{}")))
+	if w.Code != http.StatusOK || w.Body.String() != "/v1/chat/completions" {
+		t.Errorf("routed request = %d %q, want the rewritten path", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// When routing decision headers are added to proxied responses
+const (
+	routingHeadersAlways = "always"
+	routingHeadersDebug  = "debug"
+	routingHeadersOff    = "off"
+)
+
+// routingHeaderNames lists the debug headers set by setRoutingHeaders
+var routingHeaderNames = []string{
+	"X-C3-Node", "X-C3-Tag", "X-C3-Balancer", "X-C3-Queue-Time", "X-C3-Upstream-Latency", "X-C3-Attempts",
+}
+
+// RoutingHeaderConfig controls the X-C3-* headers describing how a request
+// was routed. In debug mode they are only sent when the request carries
+// DebugHeader. WorkloadHeader reports the serving workload's ID on every
+// response regardless of Mode; empty disables it.
+type RoutingHeaderConfig struct {
+	Mode           string
+	DebugHeader    string
+	WorkloadHeader string
+}
+
+func loadRoutingHeaderConfig() (RoutingHeaderConfig, error) {
+	cfg := RoutingHeaderConfig{
+		Mode:           strings.ToLower(os.Getenv("ROUTING_HEADERS")),
+		DebugHeader:    os.Getenv("DEBUG_HEADER"),
+		WorkloadHeader: "X-C3-Workload",
+	}
+	if cfg.DebugHeader == "" {
+		cfg.DebugHeader = "X-C3-Debug"
+	}
+	if header, set := os.LookupEnv("WORKLOAD_HEADER"); set {
+		cfg.WorkloadHeader = header
+	}
+
+	switch cfg.Mode {
+	case "", routingHeadersOff:
+		// Node names and workload IDs are internal, so they are opt-in
+		cfg.Mode = routingHeadersOff
+	case routingHeadersAlways, routingHeadersDebug:
+	default:
+		return cfg, fmt.Errorf("unknown ROUTING_HEADERS %q", cfg.Mode)
+	}
+	return cfg, nil
+}
+
+// enabled reports whether routing headers should be added for a request
+func (c RoutingHeaderConfig) enabled(r *http.Request) bool {
+	switch c.Mode {
+	case routingHeadersAlways:
+		return true
+	case routingHeadersDebug:
+		value := strings.ToLower(r.Header.Get(c.DebugHeader))
+		return value != "" && value != "0" && value != "false"
+	default:
+		return false
+	}
+}
+
+// setRoutingHeaders reports the serving workload and, when enabled for the
+// request, describes the routing decision. Queue time is spent in the proxy
+// before the request was sent to the node; upstream latency is the time until
+// the node's response headers arrived.
+func (p *ProxyServer) setRoutingHeaders(h http.Header, r *http.Request, apiKey, node string, queued, upstream time.Duration) {
+	if header := p.routingHeaders.WorkloadHeader; header != "" {
+		if id := p.workloadID(apiKey, node); id != "" {
+			h.Set(header, id)
+		} else {
+			h.Del(header)
+		}
+	}
+	if !p.routingHeaders.enabled(r) {
+		return
+	}
+	info := getRequestInfo(r)
+
+	h.Set("X-C3-Node", node)
+	if info.Tag != "" {
+		h.Set("X-C3-Tag", info.Tag)
+	} else {
+		h.Del("X-C3-Tag")
+	}
+	if info.Balancer != "" {
+		h.Set("X-C3-Balancer", info.Balancer)
+	} else {
+		h.Del("X-C3-Balancer")
+	}
+	h.Set("X-C3-Queue-Time", formatMillis(queued))
+	h.Set("X-C3-Upstream-Latency", formatMillis(upstream))
+	h.Set("X-C3-Attempts", strconv.Itoa(info.Attempts))
+}
+
+// formatMillis formats a duration as fractional milliseconds
+func formatMillis(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 1, 64)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// routedProxy serves tag llama from a single node running workload w-1
+func routedProxy(t *testing.T, env map[string]string) *ProxyServer {
+	t.Helper()
+	node := newTestNode(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-C3-Node", "spoofed")
+		w.Write([]byte("ok"))
+	})
+	p := newTestProxy(t, env)
+	seedWorkloads(p, testKey, testWorkload(node, "w-1", "llama"))
+	return p
+}
+
+func TestWorkloadHeaderIsOnByDefault(t *testing.T) {
+	p := routedProxy(t, nil)
+
+	w := doRequest(p, httptest.NewRequest(http.MethodGet, "/tags/llama/v1/models", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if got := w.Header().Get("X-C3-Workload"); got != "w-1" {
+		t.Errorf("X-C3-Workload = %q, want w-1 with ROUTING_HEADERS unset", got)
+	}
+	if got := w.Header().Get("X-C3-Node"); got != "spoofed" {
+		t.Errorf("X-C3-Node = %q, want the node's own header untouched when routing headers are off", got)
+	}
+}
+
+func TestWorkloadHeaderName(t *testing.T) {
+	p := routedProxy(t, map[string]string{"WORKLOAD_HEADER": "X-Served-By"})
+	w := doRequest(p, httptest.NewRequest(http.MethodGet, "/tags/llama/", nil))
+	if w.Header().Get("X-Served-By") != "w-1" || w.Header().Get("X-C3-Workload") != "" {
+		t.Errorf("headers = %v, want the workload ID only in X-Served-By", w.Header())
+	}
+
+	p = routedProxy(t, map[string]string{"WORKLOAD_HEADER": ""})
+	w = doRequest(p, httptest.NewRequest(http.MethodGet, "/tags/llama/", nil))
+	if got := w.Header().Get("X-C3-Workload"); got != "" {
+		t.Errorf("X-C3-Workload = %q with WORKLOAD_HEADER empty", got)
+	}
+}
+
+func TestRoutingHeaderModes(t *testing.T) {
+	tests := []struct {
+		mode  string
+		debug bool
+		want  bool
+	}{
+		{"", false, false},
+		{"off", true, false},
+		{"always", false, true},
+		{"debug", false, false},
+		{"debug", true, true},
+	}
+
+	for _, tt := range tests {
+		p := routedProxy(t, map[string]string{"ROUTING_HEADERS": tt.mode})
+		r := httptest.NewRequest(http.MethodGet, "/tags/llama/", nil)
+		if tt.debug {
+			r.Header.Set("X-C3-Debug", "1")
+		}
+		w := doRequest(p, r)
+
+		got := w.Header().Get("X-C3-Tag") == "llama" && w.Header().Get("X-C3-Attempts") == "1" &&
+			w.Header().Get("X-C3-Node") != "spoofed"
+		if got != tt.want {
+			t.Errorf("mode %q with debug header %v: routing headers present = %v, want %v (%v)",
+				tt.mode, tt.debug, got, tt.want, w.Header())
+		}
+	}
+
+	t.Setenv("ROUTING_HEADERS", "sometimes")
+	if _, err := loadRoutingHeaderConfig(); err == nil {
+		t.Error("expected an error for an unknown ROUTING_HEADERS mode")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"os"
 	"sync"
+	"time"
 )
 
 type ProxyServer struct {
@@ -16,7 +17,45 @@ type ProxyServer struct {
 	cacheLock        sync.RWMutex
 	requestLock      sync.RWMutex
 	apiURL           string
+	headers          HeaderConfig
+	rewrites         *HeaderRewriteConfig
+	cors             CORSConfig
+	limits           *LimitsConfig
+	openAIErrors     bool
+	keys             *KeyValidator
+	fetches          *fetchGroup
+	cachePolicy      CachePolicy
+	expiry           ExpiryPolicy
+	upstream         *http.Client
+	apiClient        *http.Client
+	metrics          *Metrics
+	webhookSecret    string
+	snapshots        *SnapshotStore
+	state            StateBackend
+	rateLimit        RateLimitConfig
+	affinityHeader   string
+	affinityTTL      time.Duration
+	adminToken       string
+	draining         *drainSet
+	traffic          *trafficStats
+	tracer           *Tracer
+	balancer         string
+	latency          *latencyTracker
+	weights          *WeightConfig
+	rotation         *weightedRotation
+	selectorHeader   string
+	routes           *RouteTable
+	indexOrder       string
+	routingHeaders   RoutingHeaderConfig
+	hedging          *Hedger
+	broadcast        BroadcastConfig
+	responses        *ResponseCache
+	dashboardTick    time.Duration
+	shutdown         chan struct{}
 	logger           *Logger
+
+	workloadFetches      *Counter
+	workloadFetchesSaved *Counter
 }
 
 func NewProxyServer() (*ProxyServer, error) {
@@ -34,21 +73,169 @@ func NewProxyServer() (*ProxyServer, error) {
 	logger := NewLogger("proxy")
 	logger.Info("🚀 Starting proxy server with API URL: %s", apiURL)
 
-	return &ProxyServer{
+	headers := loadHeaderConfig(logger)
+	rewrites, err := loadHeaderRewriteConfig(logger)
+	if err != nil {
+		return nil, err
+	}
+	cors, err := loadCORSConfig(logger)
+	if err != nil {
+		return nil, err
+	}
+	limits, err := loadLimitsConfig(logger)
+	if err != nil {
+		return nil, err
+	}
+
+	cachePolicy, err := loadCachePolicy()
+	if err != nil {
+		return nil, err
+	}
+	// Workload fetches are shared between callers, so they need their own deadline
+	apiTimeout := getEnvDuration("WORKLOAD_API_TIMEOUT", 30*time.Second)
+	if apiTimeout <= 0 {
+		return nil, fmt.Errorf("WORKLOAD_API_TIMEOUT must be positive, got %v", apiTimeout)
+	}
+
+	snapshots := NewSnapshotStore()
+	if snapshots != nil {
+		count, err := snapshots.Load()
+		if err != nil {
+			logger.Warn("⚠️  Ignoring workload snapshot: %v", err)
+		} else {
+			logger.Info("💾 Loaded workload snapshot with %d API keys from %s", count, snapshots.path)
+		}
+	}
+
+	state, err := NewStateBackend(logger)
+	if err != nil {
+		return nil, err
+	}
+
+	affinityHeader := "X-C3-Session-ID"
+	if header, set := os.LookupEnv("AFFINITY_HEADER"); set {
+		affinityHeader = header
+	}
+	selectorHeader := "X-C3-Tag-Selector"
+	if header, set := os.LookupEnv("TAG_SELECTOR_HEADER"); set {
+		selectorHeader = header
+	}
+
+	tracer, err := NewTracer(logger)
+	if err != nil {
+		return nil, err
+	}
+
+	balancer, err := loadBalancerMode()
+	if err != nil {
+		return nil, err
+	}
+	weights, err := loadWeightConfig(logger)
+	if err != nil {
+		return nil, err
+	}
+	routes, err := loadRouteTable(logger)
+	if err != nil {
+		return nil, err
+	}
+	indexOrder, err := loadIndexOrder()
+	if err != nil {
+		return nil, err
+	}
+	routingHeaders, err := loadRoutingHeaderConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := NewMetrics()
+	hedging, err := loadHedger(logger, metrics)
+	if err != nil {
+		return nil, err
+	}
+	responses, err := loadResponseCache(logger, metrics)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &ProxyServer{
 		nodeCache:        make(map[string]string),
 		workloadCache:    make(map[string]*WorkloadCache),
 		inFlightRequests: make(map[string]map[string]int),
 		tagMappings:      make(map[string]map[string][]string),
 		apiURL:           apiURL,
+		headers:          headers,
+		rewrites:         rewrites,
+		cors:             cors,
+		limits:           limits,
+		openAIErrors:     getEnvBool("OPENAI_ERRORS", false),
+		keys:             NewKeyValidator(),
+		fetches:          newFetchGroup(),
+		cachePolicy:      cachePolicy,
+		expiry:           loadExpiryPolicy(),
+		upstream:         newUpstreamClient(headers),
+		apiClient:        &http.Client{Timeout: apiTimeout},
+		metrics:          metrics,
+		webhookSecret:    os.Getenv("WEBHOOK_SECRET"),
+		snapshots:        snapshots,
+		state:            state,
+		rateLimit:        loadRateLimitConfig(),
+		affinityHeader:   affinityHeader,
+		affinityTTL:      getEnvDuration("AFFINITY_TTL", 30*time.Minute),
+		adminToken:       os.Getenv("ADMIN_TOKEN"),
+		draining:         newDrainSet(),
+		traffic:          newTrafficStats(),
+		tracer:           tracer,
+		balancer:         balancer,
+		latency:          newLatencyTracker(logger),
+		weights:          weights,
+		rotation:         newWeightedRotation(),
+		selectorHeader:   selectorHeader,
+		routes:           routes,
+		indexOrder:       indexOrder,
+		routingHeaders:   routingHeaders,
+		hedging:          hedging,
+		broadcast:        loadBroadcastConfig(),
+		responses:        responses,
+		dashboardTick:    getEnvDuration("DASHBOARD_INTERVAL", 2*time.Second),
+		shutdown:         make(chan struct{}),
 		logger:           logger,
-	}, nil
+
+		workloadFetches: metrics.NewCounter("c3_proxy_workload_fetches_total",
+			"Workload fetches sent to the Comput3 API"),
+		workloadFetchesSaved: metrics.NewCounter("c3_proxy_workload_fetches_saved_total",
+			"Workload fetches answered by an in-flight fetch for the same API key"),
+	}
+	metrics.NewGaugeFunc("c3_proxy_tracked_api_keys", "API keys with cached workloads", func() float64 {
+		p.cacheLock.RLock()
+		defer p.cacheLock.RUnlock()
+		return float64(len(p.workloadCache))
+	})
+
+	return p, nil
 }
 
 func (p *ProxyServer) Start() {
 	p.logger.Info("📋 Registering HTTP handler for /")
 	http.HandleFunc("/", p.ProxyHandler)
+	if getEnvBool("METRICS_PUBLIC", false) {
+		p.logger.Warn("📋 Registering unauthenticated metrics handler for /metrics on the public port")
+		http.HandleFunc("/metrics", p.MetricsHandler)
+	}
+	if p.webhookSecret != "" {
+		p.logger.Info("📋 Registering workload webhook handler for /webhooks/workloads")
+		http.HandleFunc("/webhooks/workloads", p.WebhookHandler)
+	}
+	if p.adminToken != "" {
+		go p.startAdmin()
+	}
+	if url := os.Getenv("WORKLOAD_EVENTS_URL"); url != "" {
+		go p.subscribeWorkloadEvents(url)
+	}
+	if p.snapshots != nil {
+		go p.runSnapshots()
+	}
 	p.logger.Info("🚀 Starting proxy server on :8080")
-	
+
 	if err := http.ListenAndServe(":8080", nil); err != nil {
 		p.logger.Error("Failed to start server: %v", err)
 		os.Exit(1)
@@ -57,11 +244,20 @@ func (p *ProxyServer) Start() {
 
 func (p *ProxyServer) Cleanup() {
 	p.logger.Info("🧹 Starting cleanup...")
+	close(p.shutdown)
+	if p.snapshots != nil {
+		p.saveSnapshot()
+	}
+	p.tracer.Shutdown()
+	if err := p.state.Close(); err != nil {
+		p.logger.Debug("⚠️  Failed to close state backend: %v", err)
+	}
+
 	p.cacheLock.Lock()
 	defer p.cacheLock.Unlock()
 
 	for apiKey, cache := range p.workloadCache {
-		p.logger.Debug("🚫 Stopping refresh for API key %s...", apiKey[:8])
+		p.logger.Debug("🚫 Stopping refresh for API key %s...", maskKey(apiKey))
 		if cache.StopRefresh != nil {
 			close(cache.StopRefresh)
 		}
@@ -70,7 +266,7 @@ func (p *ProxyServer) Cleanup() {
 
 // DumpInFlightRequests logs the current state of in-flight requests
 func (p *ProxyServer) DumpInFlightRequests() {
-	if logLevel != DEBUG {
+	if currentLogLevel() != DEBUG {
 		return // Only dump in debug mode
 	}
 
@@ -88,30 +284,10 @@ func (p *ProxyServer) DumpInFlightRequests() {
 		}
 
 		if hasRequests {
-			shortKey := apiKey
-			if len(shortKey) > 8 {
-				shortKey = shortKey[:8] + "..."
-			}
-
-			p.logger.Debug("📊 In-flight requests for API key %s:", shortKey)
+			p.logger.Debug("📊 In-flight requests for API key %s...:", maskKey(apiKey))
 			for node, count := range nodes {
 				p.logger.Debug("  - Node %s: %d requests", node, count)
 			}
 		}
 	}
 }
-
-func copyHeader(dst, src http.Header) {
-	stripOrigin := os.Getenv("STRIP_ORIGIN") == "true"
-
-	for k, vv := range src {
-		// Skip Origin header if STRIP_ORIGIN is true
-		if stripOrigin && k == "Origin" {
-			continue
-		}
-
-		for _, v := range vv {
-			dst.Add(k, v)
-		}
-	}
-}
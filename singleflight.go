@@ -0,0 +1,46 @@
+package main
+
+import "sync"
+
+// fetchCall is a workload fetch that is in flight or has just completed
+type fetchCall struct {
+	done      chan struct{}
+	workloads []Workload
+	err       error
+}
+
+// fetchGroup coalesces concurrent workload fetches for the same API key so
+// that only one request per key reaches the Comput3 API at a time
+type fetchGroup struct {
+	mu    sync.Mutex
+	calls map[string]*fetchCall
+}
+
+func newFetchGroup() *fetchGroup {
+	return &fetchGroup{calls: make(map[string]*fetchCall)}
+}
+
+// Do runs fn for key unless a call for key is already in flight, in which case
+// it waits for and returns that call's result. shared reports whether the
+// result came from another caller's fetch.
+func (g *fetchGroup) Do(key string, fn func() ([]Workload, error)) (workloads []Workload, err error, shared bool) {
+	g.mu.Lock()
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-call.done
+		return call.workloads, call.err, true
+	}
+	call := &fetchCall{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(call.done)
+	}()
+
+	call.workloads, call.err = fn()
+	return call.workloads, call.err, false
+}
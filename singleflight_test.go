@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingAPI serves workloads once release is closed, counting calls
+func blockingAPI(t *testing.T) (url string, calls *atomic.Int32, release chan struct{}) {
+	t.Helper()
+	calls = new(atomic.Int32)
+	release = make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		json.NewEncoder(w).Encode([]Workload{testWorkload("node-a", "w-1", "llama")})
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, calls, release
+}
+
+func TestFetchWorkloadsCoalescesConcurrentCalls(t *testing.T) {
+	url, calls, release := blockingAPI(t)
+	p := newTestProxy(t, map[string]string{"API_URL": url})
+
+	// The first caller goes away while the fetch it started is still running
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	results := make(chan error, 5)
+	go func() {
+		_, err := p.fetchWorkloads(leaderCtx, testKey)
+		results <- err
+	}()
+	waitFor(t, "the first fetch to reach the API", func() bool { return calls.Load() == 1 })
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			workloads, err := p.fetchWorkloads(context.Background(), testKey)
+			if err == nil && len(workloads) != 1 {
+				t.Errorf("shared fetch returned %d workloads, want 1", len(workloads))
+			}
+			results <- err
+		}()
+	}
+	// Give the other callers time to join the fetch in flight
+	time.Sleep(50 * time.Millisecond)
+	cancelLeader()
+	close(release)
+	wg.Wait()
+
+	for i := 0; i < 5; i++ {
+		if err := <-results; err != nil {
+			t.Errorf("fetch failed: %v", err)
+		}
+	}
+	if calls.Load() != 1 || p.workloadFetches.Value() != 1 {
+		t.Errorf("%d API calls (%d counted), want 1", calls.Load(), p.workloadFetches.Value())
+	}
+	if saved := p.workloadFetchesSaved.Value(); saved != 4 {
+		t.Errorf("workload fetches saved = %d, want 4", saved)
+	}
+}
+
+func TestFetchWorkloadsTimesOut(t *testing.T) {
+	url, _, release := blockingAPI(t)
+	defer close(release)
+	p := newTestProxy(t, map[string]string{"API_URL": url, "WORKLOAD_API_TIMEOUT": "50ms"})
+
+	start := time.Now()
+	if _, err := p.fetchWorkloads(context.Background(), testKey); err == nil {
+		t.Fatal("fetch from a hung API succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("fetch gave up after %v, want about 50ms", elapsed)
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// snapshotVersion is bumped whenever the snapshot format changes
+const snapshotVersion = 1
+
+// snapshotFile is the on-disk format of the workload cache snapshot. Entries
+// are keyed by API key hash and encrypted with a key derived from the API key
+// itself, so a snapshot reveals nothing about keys that have not been seen again.
+type snapshotFile struct {
+	Version int                      `json:"version"`
+	Created time.Time                `json:"created"`
+	Entries map[string]snapshotEntry `json:"entries"`
+}
+
+type snapshotEntry struct {
+	Saved time.Time `json:"saved"`
+	Nonce []byte    `json:"nonce"`
+	Data  []byte    `json:"data"`
+}
+
+// snapshotPayload is the plaintext stored in each entry
+type snapshotPayload struct {
+	Workloads []Workload `json:"workloads"`
+	LastFetch time.Time  `json:"last_fetch"`
+}
+
+// SnapshotStore persists the workload cache across restarts
+type SnapshotStore struct {
+	path     string
+	secret   []byte
+	interval time.Duration
+	maxAge   time.Duration
+
+	mu      sync.Mutex
+	entries map[string]snapshotEntry // loaded entries not yet restored
+}
+
+// NewSnapshotStore returns nil when SNAPSHOT_PATH is not set
+func NewSnapshotStore() *SnapshotStore {
+	path := os.Getenv("SNAPSHOT_PATH")
+	if path == "" {
+		return nil
+	}
+	return &SnapshotStore{
+		path:     path,
+		secret:   []byte(os.Getenv("SNAPSHOT_SECRET")),
+		interval: getEnvDuration("SNAPSHOT_INTERVAL", 5*time.Minute),
+		maxAge:   getEnvDuration("SNAPSHOT_MAX_AGE", 24*time.Hour),
+		entries:  make(map[string]snapshotEntry),
+	}
+}
+
+// cipherFor derives the AES-GCM cipher for an API key's snapshot entry
+func (s *SnapshotStore) cipherFor(apiKey string) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte("c3-node-proxy snapshot v1:"))
+	mac.Write([]byte(apiKey))
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (s *SnapshotStore) seal(apiKey string, payload snapshotPayload) (snapshotEntry, error) {
+	aead, err := s.cipherFor(apiKey)
+	if err != nil {
+		return snapshotEntry{}, err
+	}
+	plaintext, err := json.Marshal(payload)
+	if err != nil {
+		return snapshotEntry{}, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return snapshotEntry{}, err
+	}
+	return snapshotEntry{
+		Saved: time.Now(),
+		Nonce: nonce,
+		Data:  aead.Seal(nil, nonce, plaintext, []byte(hashKey(apiKey))),
+	}, nil
+}
+
+func (s *SnapshotStore) open(apiKey string, entry snapshotEntry) (snapshotPayload, error) {
+	var payload snapshotPayload
+	aead, err := s.cipherFor(apiKey)
+	if err != nil {
+		return payload, err
+	}
+	plaintext, err := aead.Open(nil, entry.Nonce, entry.Data, []byte(hashKey(apiKey)))
+	if err != nil {
+		return payload, err
+	}
+	err = json.Unmarshal(plaintext, &payload)
+	return payload, err
+}
+
+// Load reads the snapshot file. A missing file is not an error.
+func (s *SnapshotStore) Load() (int, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var file snapshotFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return 0, fmt.Errorf("invalid snapshot %s: %v", s.path, err)
+	}
+	if file.Version != snapshotVersion {
+		return 0, fmt.Errorf("unsupported snapshot version %d", file.Version)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, entry := range file.Entries {
+		if time.Since(entry.Saved) <= s.maxAge {
+			s.entries[hash] = entry
+		}
+	}
+	return len(s.entries), nil
+}
+
+// take removes and decrypts the snapshot entry for an API key
+func (s *SnapshotStore) take(apiKey string) (snapshotPayload, bool) {
+	hash := hashKey(apiKey)
+
+	s.mu.Lock()
+	entry, exists := s.entries[hash]
+	delete(s.entries, hash)
+	s.mu.Unlock()
+
+	if !exists {
+		return snapshotPayload{}, false
+	}
+	payload, err := s.open(apiKey, entry)
+	if err != nil {
+		return snapshotPayload{}, false
+	}
+	return payload, true
+}
+
+// Save writes the given payloads plus any loaded entries that have not been
+// restored yet, replacing the snapshot file atomically
+func (s *SnapshotStore) Save(payloads map[string]snapshotPayload) error {
+	file := snapshotFile{
+		Version: snapshotVersion,
+		Created: time.Now(),
+		Entries: make(map[string]snapshotEntry),
+	}
+
+	s.mu.Lock()
+	for hash, entry := range s.entries {
+		if time.Since(entry.Saved) <= s.maxAge {
+			file.Entries[hash] = entry
+		}
+	}
+	s.mu.Unlock()
+
+	for apiKey, payload := range payloads {
+		entry, err := s.seal(apiKey, payload)
+		if err != nil {
+			return err
+		}
+		file.Entries[hashKey(apiKey)] = entry
+	}
+
+	data, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".snapshot-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// restoreSnapshot seeds the cache for a key from the snapshot. Restored
+// workloads are served as stale data until the first successful refresh, and
+// only within the stale-if-error window of when they were fetched.
+func (p *ProxyServer) restoreSnapshot(apiKey string) ([]Workload, bool) {
+	if p.snapshots == nil {
+		return nil, false
+	}
+	payload, ok := p.snapshots.take(apiKey)
+	if !ok {
+		return nil, false
+	}
+	if age := time.Since(payload.LastFetch); age > p.cachePolicy.FreshTTL+p.cachePolicy.StaleIfError {
+		p.logger.Debug("💾 Snapshot for API key %s... is %v old, too old to serve",
+			maskKey(apiKey), age.Round(time.Second))
+		return nil, false
+	}
+	if err := p.ensureTracked(apiKey); err != nil {
+		return nil, false
+	}
+
+	p.updateCache(apiKey, payload.Workloads)
+
+	p.cacheLock.Lock()
+	cache := p.workloadCache[apiKey]
+	if cache != nil {
+		cache.LastFetch = payload.LastFetch
+		cache.Restored = true
+	}
+	p.cacheLock.Unlock()
+	if cache == nil {
+		return nil, false
+	}
+
+	p.logger.Info("💾 Restored %d workloads for API key %s... from snapshot taken %v ago",
+		len(payload.Workloads), maskKey(apiKey), time.Since(payload.LastFetch).Round(time.Second))
+
+	p.startCacheRefresh(apiKey)
+	p.revalidate(apiKey, cache)
+	return payload.Workloads, true
+}
+
+// saveSnapshot writes the current workload cache to disk
+func (p *ProxyServer) saveSnapshot() {
+	payloads := make(map[string]snapshotPayload)
+	p.cacheLock.RLock()
+	for apiKey, cache := range p.workloadCache {
+		if cache.Workloads != nil {
+			payloads[apiKey] = snapshotPayload{Workloads: cache.Workloads, LastFetch: cache.LastFetch}
+		}
+	}
+	p.cacheLock.RUnlock()
+
+	if err := p.snapshots.Save(payloads); err != nil {
+		p.logger.Error("Failed to save workload snapshot: %v", err)
+		return
+	}
+	p.logger.Debug("💾 Saved workload snapshot for %d API keys", len(payloads))
+}
+
+// runSnapshots periodically saves the workload cache until shutdown
+func (p *ProxyServer) runSnapshots() {
+	ticker := time.NewTicker(p.snapshots.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.saveSnapshot()
+		case <-p.shutdown:
+			return
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnapshotSealAndOpen(t *testing.T) {
+	store := &SnapshotStore{secret: []byte("secret")}
+	payload := snapshotPayload{Workloads: []Workload{testWorkload("node-a", "w-1", "llama")}, LastFetch: time.Now()}
+
+	entry, err := store.seal(testKey, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened, err := store.open(testKey, entry)
+	if err != nil || len(opened.Workloads) != 1 || opened.Workloads[0].Workload != "w-1" {
+		t.Fatalf("open = %+v, %v", opened, err)
+	}
+
+	if _, err := store.open("other-key-0123456789", entry); err == nil {
+		t.Error("another API key opened the entry")
+	}
+	other := &SnapshotStore{secret: []byte("other secret")}
+	if _, err := other.open(testKey, entry); err == nil {
+		t.Error("another secret opened the entry")
+	}
+	entry.Data[0] ^= 1
+	if _, err := store.open(testKey, entry); err == nil {
+		t.Error("a tampered entry was opened")
+	}
+}
+
+func TestSnapshotSaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	store := &SnapshotStore{path: path, maxAge: time.Hour, entries: make(map[string]snapshotEntry)}
+	payloads := map[string]snapshotPayload{
+		testKey: {Workloads: []Workload{testWorkload("node-a", "w-1", "llama")}, LastFetch: time.Now()},
+	}
+	if err := store.Save(payloads); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{testKey, "node-a", "llama"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("snapshot file contains %q in the clear", secret)
+		}
+	}
+
+	loaded := &SnapshotStore{path: path, maxAge: time.Hour, entries: make(map[string]snapshotEntry)}
+	if count, err := loaded.Load(); err != nil || count != 1 {
+		t.Fatalf("Load = %d, %v, want 1 entry", count, err)
+	}
+	if _, ok := loaded.take("other-key-0123456789"); ok {
+		t.Error("took an entry for a key that was never saved")
+	}
+	payload, ok := loaded.take(testKey)
+	if !ok || len(payload.Workloads) != 1 || payload.Workloads[0].Node != "node-a" {
+		t.Fatalf("take = %+v, %v", payload, ok)
+	}
+	if _, ok := loaded.take(testKey); ok {
+		t.Error("an entry was restored twice")
+	}
+}
+
+// snapshotProxy starts a proxy whose Comput3 API is down and whose snapshot
+// holds testKey's workloads as fetched at lastFetch
+func snapshotProxy(t *testing.T, lastFetch time.Time) *ProxyServer {
+	t.Helper()
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(api.Close)
+
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	store := &SnapshotStore{path: path, maxAge: 24 * time.Hour, entries: make(map[string]snapshotEntry)}
+	payloads := map[string]snapshotPayload{
+		testKey: {Workloads: []Workload{testWorkload("node-a", "w-1", "llama")}, LastFetch: lastFetch},
+	}
+	if err := store.Save(payloads); err != nil {
+		t.Fatal(err)
+	}
+	return newTestProxy(t, map[string]string{
+		"API_URL":              api.URL,
+		"SNAPSHOT_PATH":        path,
+		"CACHE_FRESH_TTL":      "1m",
+		"CACHE_STALE_IF_ERROR": "10m",
+	})
+}
+
+func TestSnapshotRestoreServesRecentWorkloads(t *testing.T) {
+	p := snapshotProxy(t, time.Now().Add(-5*time.Minute))
+
+	workloads, err := p.getWorkloads(context.Background(), testKey)
+	if err != nil || len(workloads) != 1 {
+		t.Fatalf("getWorkloads = %v, %v, want the restored workload", workloads, err)
+	}
+
+	// Once the stale-if-error window has passed, restored data is not served
+	p.cacheLock.Lock()
+	p.workloadCache[testKey].LastFetch = time.Now().Add(-12 * time.Minute)
+	p.cacheLock.Unlock()
+	if workloads, err := p.getWorkloads(context.Background(), testKey); err == nil {
+		t.Errorf("getWorkloads served %d restored workloads past the stale-if-error window", len(workloads))
+	}
+}
+
+func TestSnapshotRestoreSkipsOldWorkloads(t *testing.T) {
+	p := snapshotProxy(t, time.Now().Add(-2*time.Hour))
+
+	if workloads, err := p.getWorkloads(context.Background(), testKey); err == nil {
+		t.Errorf("getWorkloads served %d workloads from a two-hour-old snapshot", len(workloads))
+	}
+}
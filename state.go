@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StateBackend holds state shared between proxy replicas. Each replica keeps
+// its own in-flight counts locally and publishes them so peers can balance
+// with the whole cluster's load in mind.
+type StateBackend interface {
+	// PublishInFlight records this replica's in-flight count for a node. It
+	// is called with requestLock held, so it must not block on the network.
+	PublishInFlight(apiKey, node string, count int) error
+	// PeerInFlight returns the in-flight counts of other live replicas, summed
+	// per node. Counts may be cached for a short interval.
+	PeerInFlight(apiKey string) (map[string]int, error)
+	// GetAffinity returns the node pinned to a session, if any
+	GetAffinity(key string) (string, bool, error)
+	// SetAffinity pins a session to a node for ttl
+	SetAffinity(key, node string, ttl time.Duration) error
+	// IncrementBucket increments the rate-limit bucket for key, creating it
+	// with the given lifetime, and returns the new count
+	IncrementBucket(key string, window time.Duration) (int64, error)
+	Close() error
+}
+
+// NewStateBackend creates the backend selected by STATE_BACKEND
+func NewStateBackend(logger *Logger) (StateBackend, error) {
+	switch backend := strings.ToLower(os.Getenv("STATE_BACKEND")); backend {
+	case "", "memory":
+		return newMemoryState(), nil
+	case "redis":
+		url := os.Getenv("REDIS_URL")
+		if url == "" {
+			return nil, fmt.Errorf("REDIS_URL is required when STATE_BACKEND=redis")
+		}
+		state, err := newRedisState(url, replicaID(), logger)
+		if err != nil {
+			return nil, err
+		}
+		logger.Info("🗄️  Using Redis state backend as replica %s", state.replica)
+		return state, nil
+	default:
+		return nil, fmt.Errorf("unknown STATE_BACKEND %q", backend)
+	}
+}
+
+// replicaID identifies this proxy instance in shared state
+func replicaID() string {
+	if id := os.Getenv("REPLICA_ID"); id != "" {
+		return id
+	}
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+type memoryEntry struct {
+	value   string
+	count   int64
+	expires time.Time
+}
+
+// memoryState is the single-replica backend. It has no peers, so in-flight
+// publishing is a no-op; affinity and rate-limit buckets live in memory.
+type memoryState struct {
+	mu        sync.Mutex
+	entries   map[string]*memoryEntry
+	lastPurge time.Time
+}
+
+func newMemoryState() *memoryState {
+	return &memoryState{entries: make(map[string]*memoryEntry), lastPurge: time.Now()}
+}
+
+func (m *memoryState) PublishInFlight(apiKey, node string, count int) error { return nil }
+
+func (m *memoryState) PeerInFlight(apiKey string) (map[string]int, error) { return nil, nil }
+
+// get returns a live entry; callers must hold m.mu
+func (m *memoryState) get(key string) *memoryEntry {
+	now := time.Now()
+	if now.Sub(m.lastPurge) > time.Minute {
+		for k, entry := range m.entries {
+			if now.After(entry.expires) {
+				delete(m.entries, k)
+			}
+		}
+		m.lastPurge = now
+	}
+
+	entry, exists := m.entries[key]
+	if !exists || now.After(entry.expires) {
+		return nil
+	}
+	return entry
+}
+
+func (m *memoryState) GetAffinity(key string) (string, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if entry := m.get("affinity:" + key); entry != nil {
+		return entry.value, true, nil
+	}
+	return "", false, nil
+}
+
+func (m *memoryState) SetAffinity(key, node string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries["affinity:"+key] = &memoryEntry{value: node, expires: time.Now().Add(ttl)}
+	return nil
+}
+
+func (m *memoryState) IncrementBucket(key string, window time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry := m.get("bucket:" + key)
+	if entry == nil {
+		entry = &memoryEntry{expires: time.Now().Add(window)}
+		m.entries["bucket:"+key] = entry
+	}
+	entry.count++
+	return entry.count, nil
+}
+
+func (m *memoryState) Close() error { return nil }
+
+// RateLimitConfig limits requests per API key within fixed windows
+type RateLimitConfig struct {
+	Requests int
+	Window   time.Duration
+}
+
+func loadRateLimitConfig() RateLimitConfig {
+	return RateLimitConfig{
+		Requests: getEnvInt("RATE_LIMIT_REQUESTS", 0),
+		Window:   getEnvDuration("RATE_LIMIT_WINDOW", time.Minute),
+	}
+}
+
+// checkRateLimit counts the request against the key's current window. Backend
+// errors fail open so a state outage does not take the proxy down.
+func (p *ProxyServer) checkRateLimit(w http.ResponseWriter, apiKey string) error {
+	if p.rateLimit.Requests <= 0 || p.rateLimit.Window <= 0 {
+		return nil
+	}
+
+	now := time.Now()
+	windowStart := now.Truncate(p.rateLimit.Window)
+	bucket := fmt.Sprintf("%s:%d", hashKey(apiKey), windowStart.Unix())
+
+	count, err := p.state.IncrementBucket(bucket, p.rateLimit.Window)
+	if err != nil {
+		p.logger.Warn("⚠️  Rate limit check failed, allowing request: %v", err)
+		return nil
+	}
+	if count > int64(p.rateLimit.Requests) {
+		retryAfter := windowStart.Add(p.rateLimit.Window).Sub(now)
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+		p.logger.Debug("🚦 Rate limit of %d requests per %v exceeded for API key %s...",
+			p.rateLimit.Requests, p.rateLimit.Window, maskKey(apiKey))
+		return newProxyError(http.StatusTooManyRequests, CodeRateLimited,
+			"Rate limit of %d requests per %v exceeded", p.rateLimit.Requests, p.rateLimit.Window)
+	}
+	return nil
+}
+
+// affinityKey identifies a client session for sticky routing within a tag
+func affinityKey(apiKey, tag, session string) string {
+	return hashKey(apiKey + "\x00" + tag + "\x00" + session)
+}
+
+// selectNodeForTag picks a node for a tag, honouring session affinity when the
+// client sends a session header and the pinned node still serves the tag and
+// is below its capacity. Otherwise the session is pinned to a newly picked node.
+func (p *ProxyServer) selectNodeForTag(r *http.Request, apiKey, tag string) (string, error) {
+	session := ""
+	if p.affinityHeader != "" {
+		session = r.Header.Get(p.affinityHeader)
+	}
+	if session == "" {
+		return p.getLeastBusyNode(r.Context(), apiKey, tag)
+	}
+
+	key := affinityKey(apiKey, tag, session)
+	if node, exists, err := p.state.GetAffinity(key); err != nil {
+		p.logger.Warn("⚠️  Session affinity lookup failed: %v", err)
+	} else if exists && p.nodeServesTag(apiKey, tag, node) {
+		p.cacheLock.RLock()
+		workload := p.workloadsByNode(apiKey)[node]
+		p.cacheLock.RUnlock()
+
+		if err := p.checkNodeCapacity(apiKey, workload); err != nil {
+			p.logger.Debug("📌 Session affinity: pinned node %s is at capacity, selecting another", node)
+		} else {
+			p.logger.Debug("📌 Session affinity: routing to pinned node %s", node)
+			spanFromContext(r.Context()).SetAttr("c3.affinity", true)
+			getRequestInfo(r).Balancer = "affinity"
+			return node, nil
+		}
+	}
+
+	node, err := p.getLeastBusyNode(r.Context(), apiKey, tag)
+	if err != nil {
+		return "", err
+	}
+	if err := p.state.SetAffinity(key, node, p.affinityTTL); err != nil {
+		p.logger.Warn("⚠️  Failed to store session affinity: %v", err)
+	}
+	return node, nil
+}
+
+// nodeServesTag reports whether a node is currently running with the given tag
+// and is neither about to expire nor draining
+func (p *ProxyServer) nodeServesTag(apiKey, tag, node string) bool {
+	p.cacheLock.RLock()
+	defer p.cacheLock.RUnlock()
+
+	if p.isExpiring(apiKey, node) || p.draining.isDraining(node) {
+		return false
+	}
+
+	if tag == "all" {
+		if cache, exists := p.workloadCache[apiKey]; exists {
+			for _, w := range cache.Workloads {
+				if w.Node == node && w.Running && w.Status == "running" {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	nodes, err := p.nodesForSelector(apiKey, tag)
+	if err != nil {
+		return false
+	}
+	for _, candidate := range nodes {
+		if candidate == node {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryStateInFlightHasNoPeers(t *testing.T) {
+	state := newMemoryState()
+	if err := state.PublishInFlight("key", "node-a", 3); err != nil {
+		t.Fatal(err)
+	}
+	counts, err := state.PeerInFlight("key")
+	if err != nil || len(counts) != 0 {
+		t.Errorf("PeerInFlight = %v, %v, want no peers", counts, err)
+	}
+}
+
+func TestMemoryStateAffinity(t *testing.T) {
+	state := newMemoryState()
+
+	if _, exists, _ := state.GetAffinity("session"); exists {
+		t.Fatal("GetAffinity found a session that was never set")
+	}
+	state.SetAffinity("session", "node-a", time.Minute)
+	if node, exists, err := state.GetAffinity("session"); err != nil || !exists || node != "node-a" {
+		t.Fatalf("GetAffinity = %q, %v, %v", node, exists, err)
+	}
+
+	state.SetAffinity("session", "node-b", time.Minute)
+	if node, _, _ := state.GetAffinity("session"); node != "node-b" {
+		t.Errorf("GetAffinity = %q after re-pinning, want node-b", node)
+	}
+
+	state.SetAffinity("expired", "node-a", -time.Second)
+	if _, exists, _ := state.GetAffinity("expired"); exists {
+		t.Error("GetAffinity returned an expired session")
+	}
+}
+
+func TestMemoryStateIncrementBucket(t *testing.T) {
+	state := newMemoryState()
+
+	for want := int64(1); want <= 3; want++ {
+		if count, err := state.IncrementBucket("bucket", time.Minute); err != nil || count != want {
+			t.Fatalf("IncrementBucket = %d, %v, want %d", count, err, want)
+		}
+	}
+	if count, _ := state.IncrementBucket("other", time.Minute); count != 1 {
+		t.Errorf("IncrementBucket for a new bucket = %d, want 1", count)
+	}
+
+	// An expired bucket starts over
+	state.mu.Lock()
+	state.entries["bucket:bucket"].expires = time.Now().Add(-time.Second)
+	state.mu.Unlock()
+	if count, _ := state.IncrementBucket("bucket", time.Minute); count != 1 {
+		t.Errorf("IncrementBucket after expiry = %d, want 1", count)
+	}
+}
+
+func TestMemoryStatePurgesExpiredEntries(t *testing.T) {
+	state := newMemoryState()
+	state.SetAffinity("old", "node-a", -time.Second)
+	state.SetAffinity("live", "node-a", time.Minute)
+
+	state.mu.Lock()
+	state.lastPurge = time.Now().Add(-2 * time.Minute)
+	state.get("live")
+	_, oldKept := state.entries["affinity:old"]
+	_, liveKept := state.entries["affinity:live"]
+	state.mu.Unlock()
+
+	if oldKept || !liveKept {
+		t.Errorf("after purge old kept = %v, live kept = %v", oldKept, liveKept)
+	}
+}
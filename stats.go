@@ -0,0 +1,89 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Recent traffic is kept per node in fixed buckets covering statsWindow
+const (
+	statsBucketWidth = 5 * time.Second
+	statsBuckets     = 12
+	statsWindow      = statsBucketWidth * statsBuckets
+)
+
+type statsBucket struct {
+	slot     int64 // bucket start in units of statsBucketWidth since the epoch
+	requests int
+	errors   int
+	latency  time.Duration
+}
+
+// trafficStats records recent upstream latency and errors per node
+type trafficStats struct {
+	mu    sync.Mutex
+	nodes map[string]*[statsBuckets]statsBucket
+}
+
+func newTrafficStats() *trafficStats {
+	return &trafficStats{nodes: make(map[string]*[statsBuckets]statsBucket)}
+}
+
+// record adds one upstream request. latency is the time until response
+// headers arrived; failed marks transport errors and 5xx responses.
+func (s *trafficStats) record(node string, latency time.Duration, failed bool) {
+	slot := time.Now().UnixNano() / int64(statsBucketWidth)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	buckets, exists := s.nodes[node]
+	if !exists {
+		buckets = new([statsBuckets]statsBucket)
+		s.nodes[node] = buckets
+	}
+	b := &buckets[slot%statsBuckets]
+	if b.slot != slot {
+		*b = statsBucket{slot: slot}
+	}
+	b.requests++
+	b.latency += latency
+	if failed {
+		b.errors++
+	}
+}
+
+// nodeTraffic summarizes a node's requests within statsWindow
+type nodeTraffic struct {
+	Requests  int     `json:"requests"`
+	Errors    int     `json:"errors"`
+	ErrorRate float64 `json:"error_rate"`
+	LatencyMs int64   `json:"latency_ms"` // mean time to response headers
+}
+
+func (s *trafficStats) recent(node string) nodeTraffic {
+	oldest := time.Now().UnixNano()/int64(statsBucketWidth) - statsBuckets + 1
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var traffic nodeTraffic
+	buckets, exists := s.nodes[node]
+	if !exists {
+		return traffic
+	}
+	var latency time.Duration
+	for _, b := range buckets {
+		if b.slot < oldest {
+			continue
+		}
+		traffic.Requests += b.requests
+		traffic.Errors += b.errors
+		latency += b.latency
+	}
+	if traffic.Requests > 0 {
+		traffic.ErrorRate = float64(traffic.Errors) / float64(traffic.Requests)
+		traffic.LatencyMs = (latency / time.Duration(traffic.Requests)).Milliseconds()
+	}
+	return traffic
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Tag selectors combine tags in /tags/{selector} routes or the selector header:
+//
+//	llama+70b        nodes with both tags
+//	llama,mistral    nodes with either tag
+//	llama!staging    llama nodes without the staging tag (also llama!-staging)
+//
+// "+" and "!" bind tighter than ",", so a+b,c means (a and b) or c.
+const tagOperators = "+,!"
+
+// tagReserved are characters a tag cannot contain, since tags are also path
+// segments in /tags/{tag} routes
+const tagReserved = "/?# \t\r\n"
+
+type tagTerm struct {
+	tag    string
+	negate bool
+}
+
+// tagSelector is a parsed selector: a union of intersections
+type tagSelector struct {
+	clauses [][]tagTerm
+}
+
+// isTagExpression reports whether a route tag uses selector syntax
+func isTagExpression(tag string) bool {
+	return strings.ContainsAny(tag, tagOperators)
+}
+
+func parseTagSelector(expr string) (*tagSelector, error) {
+	selector := &tagSelector{}
+	for _, clause := range strings.Split(expr, ",") {
+		var terms []tagTerm
+		for i, part := range strings.Split(clause, "+") {
+			segments := strings.Split(part, "!")
+			for j, segment := range segments {
+				negate := j > 0
+				if negate {
+					segment = strings.TrimPrefix(segment, "-")
+				}
+				segment = strings.TrimSpace(segment)
+				if segment == "" {
+					if j == 0 && len(segments) > 1 {
+						continue // leading "!" negates the first term
+					}
+					return nil, fmt.Errorf("empty tag in selector %q (term %d)", expr, i+1)
+				}
+				if strings.ContainsAny(segment, tagReserved) {
+					return nil, fmt.Errorf("invalid tag %q in selector %q", segment, expr)
+				}
+				terms = append(terms, tagTerm{tag: segment, negate: negate})
+			}
+		}
+		selector.clauses = append(selector.clauses, terms)
+	}
+	return selector, nil
+}
+
+// matches evaluates the selector against a node's tags
+func (s *tagSelector) matches(tags map[string]bool) bool {
+	for _, clause := range s.clauses {
+		matched := true
+		for _, term := range clause {
+			if tags[term.tag] == term.negate {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// tagSelector returns the selector header value, if the header is enabled
+func (p *ProxyServer) tagSelector(r *http.Request) string {
+	if p.selectorHeader == "" {
+		return ""
+	}
+	return strings.TrimSpace(r.Header.Get(p.selectorHeader))
+}
+
+// nodesForSelector returns the running nodes matching a tag or tag selector;
+// callers must hold cacheLock
+func (p *ProxyServer) nodesForSelector(apiKey, tag string) ([]string, error) {
+	tagMap, exists := p.tagMappings[apiKey]
+	if !exists {
+		return nil, newProxyError(http.StatusServiceUnavailable, CodeNoHealthyNodes, "no nodes found for API key")
+	}
+
+	if !isTagExpression(tag) {
+		nodes := tagMap[tag]
+		if len(nodes) == 0 {
+			return nil, newProxyError(http.StatusNotFound, CodeUnknownTag, "no nodes found for tag: %s", tag)
+		}
+		return nodes, nil
+	}
+
+	selector, err := parseTagSelector(tag)
+	if err != nil {
+		return nil, newProxyError(http.StatusBadRequest, CodeInvalidSelector, "%v", err)
+	}
+
+	// Every running node is a candidate, so pure exclusions such as !staging work
+	nodeTags := make(map[string]map[string]bool)
+	for node := range p.workloadsByNode(apiKey) {
+		nodeTags[node] = make(map[string]bool)
+	}
+	for t, nodes := range tagMap {
+		for _, node := range nodes {
+			if nodeTags[node] == nil {
+				nodeTags[node] = make(map[string]bool)
+			}
+			nodeTags[node][t] = true
+		}
+	}
+
+	var nodes []string
+	for node, tags := range nodeTags {
+		if selector.matches(tags) {
+			nodes = append(nodes, node)
+		}
+	}
+	if len(nodes) == 0 {
+		return nil, newProxyError(http.StatusNotFound, CodeUnknownTag, "no nodes match tag selector: %s", tag)
+	}
+	sort.Strings(nodes)
+	p.logger.Debug("🏷️  Tag selector %s matched nodes %v", tag, nodes)
+	return nodes, nil
+}
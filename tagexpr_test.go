@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseTagSelector(t *testing.T) {
+	tags := func(names ...string) map[string]bool {
+		set := make(map[string]bool)
+		for _, name := range names {
+			set[name] = true
+		}
+		return set
+	}
+	tests := []struct {
+		expr     string
+		matching []map[string]bool
+		others   []map[string]bool
+	}{
+		{"llama+70b", []map[string]bool{tags("llama", "70b", "gpu")}, []map[string]bool{tags("llama"), tags("70b")}},
+		{"llama,mistral", []map[string]bool{tags("llama"), tags("mistral")}, []map[string]bool{tags("bge")}},
+		{"llama!staging", []map[string]bool{tags("llama")}, []map[string]bool{tags("llama", "staging")}},
+		{"llama!-staging", []map[string]bool{tags("llama")}, []map[string]bool{tags("llama", "staging")}},
+		{"!staging", []map[string]bool{tags(), tags("llama")}, []map[string]bool{tags("staging")}},
+		{"a+b,c", []map[string]bool{tags("a", "b"), tags("c")}, []map[string]bool{tags("a"), tags("b")}},
+		{" llama + 70b ", []map[string]bool{tags("llama", "70b")}, []map[string]bool{tags("llama")}},
+	}
+	for _, tt := range tests {
+		selector, err := parseTagSelector(tt.expr)
+		if err != nil {
+			t.Errorf("parseTagSelector(%q): %v", tt.expr, err)
+			continue
+		}
+		for _, set := range tt.matching {
+			if !selector.matches(set) {
+				t.Errorf("%q does not match %v", tt.expr, set)
+			}
+		}
+		for _, set := range tt.others {
+			if selector.matches(set) {
+				t.Errorf("%q matches %v", tt.expr, set)
+			}
+		}
+	}
+
+	for _, expr := range []string{"llama+", ",llama", "llama!", "a++b", "llama/70b+a", "a b,c"} {
+		if _, err := parseTagSelector(expr); err == nil {
+			t.Errorf("parseTagSelector(%q) accepted a malformed selector", expr)
+		}
+	}
+}
+
+func TestSelectorHeaderKeepsExplicitTargets(t *testing.T) {
+	handler := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(name + " " + r.URL.Path)) }
+	}
+	llama := newTestNode(t, handler("llama"))
+	bge := newTestNode(t, handler("bge"))
+	p := newTestProxy(t, nil)
+	seedWorkloads(p, testKey, testWorkload(llama, "w-llama", "llama"), testWorkload(bge, "w-bge", "bge"))
+
+	tests := []struct{ path, want string }{
+		{"/v1/embeddings", "bge /v1/embeddings"},
+		{"/tags/llama/v1/chat", "llama /v1/chat"},
+		{"/nodes/w-llama/v1/chat", "llama /v1/chat"},
+		{"/nodes/" + llama + "/v1/chat", "llama /v1/chat"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		r.Header.Set("X-C3-Tag-Selector", "bge")
+		if w := doRequest(p, r); w.Body.String() != tt.want {
+			t.Errorf("%s with selector header = %d %q, want %q", tt.path, w.Code, w.Body.String(), tt.want)
+		}
+	}
+
+	// Index paths keep their target whichever node the index lands on
+	r := httptest.NewRequest(http.MethodGet, "/0/v1/models", nil)
+	r.Header.Set("X-C3-Tag-Selector", "!llama+!bge")
+	if w := doRequest(p, r); w.Code != http.StatusOK {
+		t.Errorf("/0 with a selector matching no node = %d %q, want the indexed node", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,544 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Span kinds as numbered by OTLP
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+	spanKindClient   = 3
+)
+
+// Tracing export batching
+const (
+	traceQueueSize     = 2048
+	traceBatchSize     = 512
+	traceFlushInterval = 5 * time.Second
+)
+
+type traceID [16]byte
+type spanID [8]byte
+
+// spanContext identifies a span within a trace, as carried by W3C traceparent
+type spanContext struct {
+	TraceID traceID
+	SpanID  spanID
+	Sampled bool
+}
+
+func (sc spanContext) valid() bool {
+	return sc.TraceID != traceID{} && sc.SpanID != spanID{}
+}
+
+// traceparent formats the W3C trace context header
+func (sc spanContext) traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-" + flags
+}
+
+// parseTraceparent reads a W3C traceparent header
+func parseTraceparent(header string) (spanContext, bool) {
+	var sc spanContext
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.valid()
+}
+
+// Span is one timed operation. All methods are safe on a nil span.
+type Span struct {
+	tracer   *Tracer
+	context  spanContext
+	parentID spanID
+	name     string
+	kind     int
+	start    time.Time
+
+	mu        sync.Mutex
+	end       time.Time
+	attrs     map[string]interface{}
+	events    []spanEvent
+	errorText string
+	failed    bool
+	ended     bool
+}
+
+// SetAttr records an attribute; values should be strings, bools, ints or floats
+func (s *Span) SetAttr(key string, value interface{}) {
+	if !s.recording() {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attrs == nil {
+		s.attrs = make(map[string]interface{})
+	}
+	s.attrs[key] = value
+}
+
+// spanEvent is a point in time during a span, such as a cancelled attempt
+type spanEvent struct {
+	name  string
+	time  time.Time
+	attrs map[string]interface{}
+}
+
+// AddEvent records an event with attributes at the current time
+func (s *Span) AddEvent(name string, attrs map[string]interface{}) {
+	if !s.recording() {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, spanEvent{name: name, time: time.Now(), attrs: attrs})
+}
+
+// RecordError marks the span as failed
+func (s *Span) RecordError(err error) {
+	if err == nil || !s.recording() {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failed = true
+	s.errorText = err.Error()
+}
+
+// End finishes the span and hands it to the exporter if it is recording
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+
+	if s.recording() {
+		s.tracer.exporter.Export(s)
+	}
+}
+
+// recording reports whether the span will be exported
+func (s *Span) recording() bool {
+	return s != nil && s.context.Sampled && s.tracer.enabled
+}
+
+// inject writes the span's trace context into outgoing request headers. With
+// tracing off, headers are left alone so a client's own traceparent passes
+// through unchanged.
+func (s *Span) inject(h http.Header) {
+	if s == nil || !s.tracer.enabled {
+		return
+	}
+	h.Set("traceparent", s.context.traceparent())
+}
+
+// statusWriter records the response status for the request span
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// endRequest records the response status on a server span
+func (s *Span) endRequest(w *statusWriter) {
+	s.SetAttr("http.response.status_code", w.status)
+	if w.status >= http.StatusInternalServerError {
+		s.RecordError(fmt.Errorf("response status %d", w.status))
+	}
+}
+
+type spanKey struct{}
+type remoteSpanKey struct{}
+
+// spanFromContext returns the active span, or nil
+func spanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// extractTraceContext stores an incoming traceparent as the remote parent for new spans
+func extractTraceContext(ctx context.Context, h http.Header) context.Context {
+	if sc, ok := parseTraceparent(h.Get("traceparent")); ok {
+		return context.WithValue(ctx, remoteSpanKey{}, sc)
+	}
+	return ctx
+}
+
+// SpanExporter receives finished, sampled spans
+type SpanExporter interface {
+	Export(span *Span)
+	Shutdown()
+}
+
+// noopExporter discards spans; it is used when tracing is off
+type noopExporter struct{}
+
+func (noopExporter) Export(*Span) {}
+func (noopExporter) Shutdown()    {}
+
+// Tracer creates spans and passes them to an exporter
+type Tracer struct {
+	exporter SpanExporter
+	ratio    float64 // fraction of new root traces sampled
+	enabled  bool
+}
+
+// NewTracer configures tracing from the standard OTEL_* variables.
+// OTEL_TRACES_EXPORTER=otlp enables export over OTLP/HTTP; anything else
+// leaves tracing off, with trace context still propagated to nodes.
+func NewTracer(logger *Logger) (*Tracer, error) {
+	tracer := &Tracer{exporter: noopExporter{}, ratio: 1}
+	if arg := os.Getenv("OTEL_TRACES_SAMPLER_ARG"); arg != "" {
+		ratio, err := strconv.ParseFloat(arg, 64)
+		if err != nil || ratio < 0 || ratio > 1 {
+			return nil, fmt.Errorf("invalid OTEL_TRACES_SAMPLER_ARG %q, must be between 0 and 1", arg)
+		}
+		tracer.ratio = ratio
+	}
+
+	switch exporter := strings.ToLower(os.Getenv("OTEL_TRACES_EXPORTER")); exporter {
+	case "", "none":
+		return tracer, nil
+	case "otlp":
+		otlp, err := newOTLPExporter(logger)
+		if err != nil {
+			return nil, err
+		}
+		tracer.exporter = otlp
+		tracer.enabled = true
+		logger.Info("🔭 Exporting traces to %s (sample ratio %.2f)", otlp.endpoint, tracer.ratio)
+		return tracer, nil
+	default:
+		return nil, fmt.Errorf("unsupported OTEL_TRACES_EXPORTER %q", exporter)
+	}
+}
+
+// Start begins a span as a child of the span in ctx, or of an extracted
+// remote parent. New root traces are sampled by ratio.
+func (t *Tracer) Start(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	span := &Span{tracer: t, name: name, kind: kind, start: time.Now()}
+
+	if parent := spanFromContext(ctx); parent != nil {
+		span.context.TraceID = parent.context.TraceID
+		span.parentID = parent.context.SpanID
+		span.context.Sampled = parent.context.Sampled
+	} else if remote, ok := ctx.Value(remoteSpanKey{}).(spanContext); ok {
+		span.context.TraceID = remote.TraceID
+		span.parentID = remote.SpanID
+		span.context.Sampled = remote.Sampled
+	} else {
+		rand.Read(span.context.TraceID[:])
+		span.context.Sampled = t.enabled && t.sample(span.context.TraceID)
+	}
+	rand.Read(span.context.SpanID[:])
+
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// sample decides from the trace ID so every replica agrees on a trace
+func (t *Tracer) sample(id traceID) bool {
+	if t.ratio >= 1 {
+		return true
+	}
+	return float64(binary.BigEndian.Uint64(id[8:])>>11)/(1<<53) < t.ratio
+}
+
+func (t *Tracer) Shutdown() {
+	t.exporter.Shutdown()
+}
+
+// otlpExporter batches spans and posts them as OTLP/HTTP JSON
+type otlpExporter struct {
+	endpoint string
+	headers  map[string]string
+	service  string
+	client   *http.Client
+	logger   *Logger
+	queue    chan *Span
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newOTLPExporter(logger *Logger) (*otlpExporter, error) {
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		if base == "" {
+			base = "http://localhost:4318"
+		}
+		endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+	}
+
+	headers := make(map[string]string)
+	for _, pair := range getEnvList("OTEL_EXPORTER_OTLP_HEADERS") {
+		name, value, found := strings.Cut(pair, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid OTEL_EXPORTER_OTLP_HEADERS entry %q", pair)
+		}
+		headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+
+	service := os.Getenv("OTEL_SERVICE_NAME")
+	if service == "" {
+		service = "c3-node-proxy"
+	}
+
+	e := &otlpExporter{
+		endpoint: endpoint,
+		headers:  headers,
+		service:  service,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		logger:   logger,
+		queue:    make(chan *Span, traceQueueSize),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go e.run()
+	return e, nil
+}
+
+// Export queues a span, dropping it if the exporter has fallen behind
+func (e *otlpExporter) Export(span *Span) {
+	select {
+	case e.queue <- span:
+	default:
+		e.logger.Debug("⚠️  Trace export queue full, dropping span %s", span.name)
+	}
+}
+
+// Shutdown flushes queued spans. Spans ended afterwards are dropped.
+func (e *otlpExporter) Shutdown() {
+	e.stopOnce.Do(func() {
+		close(e.stop)
+		<-e.done
+	})
+}
+
+func (e *otlpExporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(traceFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, traceBatchSize)
+	for {
+		select {
+		case span := <-e.queue:
+			batch = append(batch, span)
+			if len(batch) >= traceBatchSize {
+				e.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			e.flush(batch)
+			batch = batch[:0]
+		case <-e.stop:
+			for {
+				select {
+				case span := <-e.queue:
+					batch = append(batch, span)
+				default:
+					e.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+func (e *otlpExporter) flush(batch []*Span) {
+	if len(batch) == 0 {
+		return
+	}
+	body, err := json.Marshal(e.payload(batch))
+	if err != nil {
+		e.logger.Error("Failed to encode %d spans: %v", len(batch), err)
+		return
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		e.logger.Error("Failed to create trace export request: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range e.headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		e.logger.Warn("⚠️  Failed to export %d spans: %v", len(batch), err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		e.logger.Warn("⚠️  Trace collector returned %d for %d spans", resp.StatusCode, len(batch))
+		return
+	}
+	e.logger.Debug("🔭 Exported %d spans", len(batch))
+}
+
+// OTLP JSON encoding, see opentelemetry-proto's trace service
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string          `json:"timeUnixNano"`
+	Name         string          `json:"name"`
+	Attributes   []otlpAttribute `json:"attributes,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Events            []otlpEvent     `json:"events,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+func otlpAttr(key string, value interface{}) otlpAttribute {
+	var v otlpValue
+	switch val := value.(type) {
+	case string:
+		v.StringValue = &val
+	case bool:
+		v.BoolValue = &val
+	case int:
+		s := strconv.Itoa(val)
+		v.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(val, 10)
+		v.IntValue = &s
+	case float64:
+		v.DoubleValue = &val
+	default:
+		s := fmt.Sprint(val)
+		v.StringValue = &s
+	}
+	return otlpAttribute{Key: key, Value: v}
+}
+
+func (e *otlpExporter) payload(batch []*Span) map[string]interface{} {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, s := range batch {
+		s.mu.Lock()
+		out := otlpSpan{
+			TraceID:           hex.EncodeToString(s.context.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.context.SpanID[:]),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		if s.parentID != (spanID{}) {
+			out.ParentSpanID = hex.EncodeToString(s.parentID[:])
+		}
+		for key, value := range s.attrs {
+			out.Attributes = append(out.Attributes, otlpAttr(key, value))
+		}
+		for _, event := range s.events {
+			outEvent := otlpEvent{TimeUnixNano: strconv.FormatInt(event.time.UnixNano(), 10), Name: event.name}
+			for key, value := range event.attrs {
+				outEvent.Attributes = append(outEvent.Attributes, otlpAttr(key, value))
+			}
+			out.Events = append(out.Events, outEvent)
+		}
+		if s.failed {
+			out.Status = otlpStatus{Code: 2, Message: s.errorText}
+		}
+		s.mu.Unlock()
+		spans = append(spans, out)
+	}
+
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": []otlpAttribute{otlpAttr("service.name", e.service)},
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "c3-node-proxy"},
+				"spans": spans,
+			}},
+		}},
+	}
+}
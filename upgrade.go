@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+)
+
+// handleUpgrade joins the client's connection to the node's once the node
+// has switched protocols, e.g. for WebSockets. Data from the node resets the
+// stream idle timer, and the request's deadlines close the tunnel.
+func (p *ProxyServer) handleUpgrade(w http.ResponseWriter, r *http.Request, resp *http.Response, node string, deadlines *requestDeadlines) {
+	requested, switched := upgradeType(r.Header), upgradeType(resp.Header)
+	if !strings.EqualFold(requested, switched) {
+		p.writeError(w, r, newProxyError(http.StatusBadGateway, CodeUpstreamError,
+			"node %s switched to protocol %q, not the requested %q", node, switched, requested))
+		return
+	}
+	backend, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		p.writeError(w, r, newProxyError(http.StatusBadGateway, CodeUpstreamError,
+			"node %s switched protocols without a usable connection", node))
+		return
+	}
+	defer backend.Close()
+
+	conn, buffered, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		p.writeError(w, r, newProxyError(http.StatusInternalServerError, CodeInternalError,
+			"connection cannot be upgraded").wrap(err))
+		return
+	}
+	defer conn.Close()
+
+	copyHeader(w.Header(), resp.Header)
+	resp.Header = w.Header()
+	resp.Body = nil
+	if err := resp.Write(buffered); err != nil {
+		p.logger.Debug("❌ Failed to send protocol switch to client: %v", err)
+		return
+	}
+	if err := buffered.Flush(); err != nil {
+		p.logger.Debug("❌ Failed to send protocol switch to client: %v", err)
+		return
+	}
+	p.logger.Debug("🔌 Switched to %s with node %s", switched, node)
+
+	// Either side closing, or the request's deadlines, ends the tunnel
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(conn, &idleReader{body: backend, deadlines: deadlines})
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(backend, buffered)
+		done <- struct{}{}
+	}()
+	select {
+	case <-done:
+	case <-deadlines.ctx.Done():
+		if cause := deadlines.cause(); cause != nil {
+			p.logTimeout(cause, node, deadlines.limits)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// minLearnedWeight keeps a node that streamed slowly once from being starved
+const minLearnedWeight = 0.1
+
+// NodeCapacity describes how much traffic a node should take. Weight is its
+// share relative to other nodes in the same tag; Capacity caps its concurrent
+// requests. Zero values mean unset.
+type NodeCapacity struct {
+	Weight   float64 `json:"weight,omitempty"`
+	Capacity int     `json:"capacity,omitempty"`
+}
+
+// merge returns c with every non-zero field of override applied
+func (c NodeCapacity) merge(override NodeCapacity) NodeCapacity {
+	if override.Weight != 0 {
+		c.Weight = override.Weight
+	}
+	if override.Capacity != 0 {
+		c.Capacity = override.Capacity
+	}
+	return c
+}
+
+// WeightConfig assigns weights and capacities by workload type and by node.
+// Node entries take precedence over type entries, which take precedence over
+// the default. With Learn set, nodes without a configured weight are weighted
+// by their observed streaming throughput.
+type WeightConfig struct {
+	Default NodeCapacity            `json:"default"`
+	Types   map[string]NodeCapacity `json:"types,omitempty"`
+	Nodes   map[string]NodeCapacity `json:"nodes,omitempty"`
+	Learn   bool                    `json:"learn,omitempty"`
+}
+
+// loadWeightConfig reads NODE_WEIGHTS (or NODE_WEIGHTS_FILE)
+func loadWeightConfig(logger *Logger) (*WeightConfig, error) {
+	cfg := &WeightConfig{}
+	found, err := loadJSONEnv("NODE_WEIGHTS", cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Default.Weight <= 0 {
+		cfg.Default.Weight = 1
+	}
+
+	types := make(map[string]NodeCapacity, len(cfg.Types))
+	for workloadType, capacity := range cfg.Types {
+		types[strings.ToLower(workloadType)] = capacity
+	}
+	cfg.Types = types
+
+	if found {
+		logger.Info("🏋️  Loaded node weights for %d workload types and %d nodes (learning %v)",
+			len(cfg.Types), len(cfg.Nodes), cfg.Learn)
+	}
+	return cfg, nil
+}
+
+// configured returns the effective settings for a node and whether its
+// weight was set explicitly by type or node
+func (c *WeightConfig) configured(node, workloadType string) (NodeCapacity, bool) {
+	capacity := c.Default
+	explicit := false
+	if override, ok := c.Types[strings.ToLower(workloadType)]; ok {
+		capacity = capacity.merge(override)
+		explicit = explicit || override.Weight != 0
+	}
+	if override, ok := c.Nodes[node]; ok {
+		capacity = capacity.merge(override)
+		explicit = explicit || override.Weight != 0
+	}
+	return capacity, explicit
+}
+
+// nodeCapacities resolves weights and capacities for candidate nodes;
+// callers must hold cacheLock
+func (p *ProxyServer) nodeCapacities(apiKey string, nodes []string) map[string]NodeCapacity {
+	byNode := p.workloadsByNode(apiKey)
+	capacities := make(map[string]NodeCapacity, len(nodes))
+
+	var learned []string
+	for _, node := range nodes {
+		capacity, explicit := p.weights.configured(node, byNode[node].Type)
+		capacities[node] = capacity
+		if p.weights.Learn && !explicit {
+			learned = append(learned, node)
+		}
+	}
+
+	// Learned weights are throughput relative to the mean of the sampled
+	// nodes, so an average node keeps the default weight
+	throughputs := make(map[string]float64)
+	var total float64
+	for _, node := range learned {
+		if entry, ok := p.latency.snapshot(node); ok && entry.throughput > 0 {
+			throughputs[node] = entry.throughput
+			total += entry.throughput
+		}
+	}
+	if len(throughputs) > 1 {
+		mean := total / float64(len(throughputs))
+		for node, throughput := range throughputs {
+			capacity := capacities[node]
+			capacity.Weight = p.weights.Default.Weight * throughput / mean
+			if capacity.Weight < minLearnedWeight {
+				capacity.Weight = minLearnedWeight
+			}
+			capacities[node] = capacity
+		}
+	}
+	return capacities
+}
+
+// withinCapacity drops nodes that have reached their concurrency cap
+func (p *ProxyServer) withinCapacity(nodes []string, capacities map[string]NodeCapacity, inFlight map[string]int) ([]string, error) {
+	available := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		if limit := capacities[node].Capacity; limit > 0 && inFlight[node] >= limit {
+			p.logger.Debug("🏋️  Skipping node %s at capacity (%d/%d)", node, inFlight[node], limit)
+			continue
+		}
+		available = append(available, node)
+	}
+	if len(available) == 0 {
+		return nil, newProxyError(http.StatusServiceUnavailable, CodeNodesAtCapacity, "all matching nodes are at capacity")
+	}
+	return available, nil
+}
+
+// checkNodeCapacity enforces a node's concurrency cap for routes that name
+// the node directly, such as index routes
+func (p *ProxyServer) checkNodeCapacity(apiKey string, w Workload) error {
+	capacity, _ := p.weights.configured(w.Node, w.Type)
+	if capacity.Capacity <= 0 {
+		return nil
+	}
+
+	peers, err := p.state.PeerInFlight(apiKey)
+	if err != nil {
+		p.logger.Warn("⚠️  Failed to read peer in-flight counts, checking capacity on local counts: %v", err)
+	}
+	p.requestLock.RLock()
+	inFlight := p.inFlightRequests[apiKey][w.Node] + peers[w.Node]
+	p.requestLock.RUnlock()
+
+	if inFlight >= capacity.Capacity {
+		p.logger.Debug("🏋️  Node %s at capacity (%d/%d)", w.Node, inFlight, capacity.Capacity)
+		return newProxyError(http.StatusServiceUnavailable, CodeNodesAtCapacity,
+			"node %s is at capacity", w.Node)
+	}
+	return nil
+}
+
+// weightedRotation spreads requests across nodes in proportion to their
+// weights with smooth weighted round-robin, so even a trickle of requests is
+// interleaved across nodes rather than all going to the heaviest one. Each
+// API key rotates separately over each set of candidate nodes, however the
+// client's selector named them.
+type weightedRotation struct {
+	mu        sync.Mutex
+	groups    map[string]map[string]*rotationGroup // by API key, then node set
+	lastPurge time.Time
+}
+
+// rotationGroup is the accumulated weight of each node in a node set
+type rotationGroup struct {
+	current  map[string]float64
+	lastUsed time.Time
+}
+
+// rotationGroupTTL is how long a node set goes unused before it is forgotten
+const rotationGroupTTL = 10 * time.Minute
+
+func newWeightedRotation() *weightedRotation {
+	return &weightedRotation{groups: make(map[string]map[string]*rotationGroup), lastPurge: time.Now()}
+}
+
+// next returns the node to send an API key's next request to. candidates is
+// every node the request could go to and picks the group; nodes is the subset
+// with capacity left. Every node in nodes gains its weight; the one with the
+// most accumulated weight is chosen and pays back the total, so over time
+// each node is chosen in proportion to it.
+func (r *weightedRotation) next(apiKey string, candidates, nodes []string, capacities map[string]NodeCapacity) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(r.lastPurge) > time.Minute {
+		r.purge(now)
+	}
+
+	sorted := slices.Clone(candidates)
+	slices.Sort(sorted)
+	set := strings.Join(sorted, "\x00")
+
+	groups, exists := r.groups[apiKey]
+	if !exists {
+		groups = make(map[string]*rotationGroup)
+		r.groups[apiKey] = groups
+	}
+	group, exists := groups[set]
+	if !exists {
+		group = &rotationGroup{current: make(map[string]float64)}
+		groups[set] = group
+	}
+	group.lastUsed = now
+
+	// A node that is skipped, e.g. for being full, starts over when it returns
+	for node := range group.current {
+		if !slices.Contains(nodes, node) {
+			delete(group.current, node)
+		}
+	}
+
+	var selected string
+	var total float64
+	for _, node := range nodes {
+		weight := capacities[node].Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		group.current[node] += weight
+		total += weight
+		if selected == "" || group.current[node] > group.current[selected] {
+			selected = node
+		}
+	}
+	group.current[selected] -= total
+	return selected
+}
+
+// forget drops an API key's groups once its workloads are no longer cached
+func (r *weightedRotation) forget(apiKey string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.groups, apiKey)
+}
+
+// purge drops node sets that have not been used recently, such as those of
+// workloads that have since ended; callers must hold mu
+func (r *weightedRotation) purge(now time.Time) {
+	for apiKey, groups := range r.groups {
+		for set, group := range groups {
+			if now.Sub(group.lastUsed) > rotationGroupTTL {
+				delete(groups, set)
+			}
+		}
+		if len(groups) == 0 {
+			delete(r.groups, apiKey)
+		}
+	}
+	r.lastPurge = now
+}
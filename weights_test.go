@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWeightedRotationIsProportional(t *testing.T) {
+	rotation := newWeightedRotation()
+	nodes := []string{"h100", "a10"}
+	capacities := map[string]NodeCapacity{"h100": {Weight: 4}, "a10": {Weight: 1}}
+
+	picks := make(map[string]int)
+	var sequence string
+	for i := 0; i < 10; i++ {
+		node := rotation.next("key", nodes, nodes, capacities)
+		picks[node]++
+		sequence += node[:1]
+	}
+	if picks["h100"] != 8 || picks["a10"] != 2 {
+		t.Errorf("picks = %v, want 8 h100 and 2 a10", picks)
+	}
+	// The light node is interleaved rather than left until the end
+	if sequence[:5] == "hhhhh" {
+		t.Errorf("sequence %s sends a burst to the heavy node", sequence)
+	}
+}
+
+func TestWeightedRotationGroupsByNodeSet(t *testing.T) {
+	rotation := newWeightedRotation()
+	capacities := map[string]NodeCapacity{"a": {Weight: 1}, "b": {Weight: 1}, "c": {Weight: 1}}
+
+	// Selectors that resolve to the same nodes share a rotation, in any order
+	first := rotation.next("key", []string{"a", "b"}, []string{"a", "b"}, capacities)
+	if got := rotation.next("key", []string{"b", "a"}, []string{"b", "a"}, capacities); got == first {
+		t.Errorf("equal weights picked %s twice in a row for the same node set", got)
+	}
+
+	// Other node sets and other API keys rotate separately
+	if got := rotation.next("key", []string{"a", "b", "c"}, []string{"a", "b", "c"}, capacities); got != "a" {
+		t.Errorf("new node set started at %s, want a", got)
+	}
+	if got := rotation.next("other", []string{"a", "b"}, []string{"a", "b"}, capacities); got != "a" {
+		t.Errorf("new API key started at %s, want a", got)
+	}
+	if len(rotation.groups["key"]) != 2 || len(rotation.groups["other"]) != 1 {
+		t.Errorf("groups = %v, want two for key and one for other", rotation.groups)
+	}
+}
+
+func TestWeightedRotationDropsSkippedNodes(t *testing.T) {
+	rotation := newWeightedRotation()
+	candidates := []string{"a", "b"}
+	capacities := map[string]NodeCapacity{"a": {Weight: 1}, "b": {Weight: 1}}
+
+	rotation.next("key", candidates, candidates, capacities)
+	rotation.next("key", candidates, []string{"b"}, capacities)
+	group := rotation.groups["key"]["a\x00b"]
+	if _, kept := group.current["a"]; kept || len(group.current) != 1 {
+		t.Errorf("weights = %v after a was skipped, want only b", group.current)
+	}
+}
+
+func TestWeightedRotationForgetsUnusedGroups(t *testing.T) {
+	rotation := newWeightedRotation()
+	nodes := []string{"a"}
+	rotation.next("old", nodes, nodes, nil)
+	rotation.next("evicted", nodes, nodes, nil)
+	rotation.next("live", nodes, nodes, nil)
+
+	rotation.forget("evicted")
+	rotation.groups["old"]["a"].lastUsed = time.Now().Add(-2 * rotationGroupTTL)
+	rotation.lastPurge = time.Now().Add(-2 * time.Minute)
+	rotation.next("live", nodes, nodes, nil)
+
+	if len(rotation.groups) != 1 || rotation.groups["live"] == nil {
+		t.Errorf("groups kept for %v, want only live", rotation.groups)
+	}
+}
+
+func TestEvictKeyForgetsRotation(t *testing.T) {
+	p := newTestProxy(t, nil)
+	seedWorkloads(p, "key", testWorkload("node-a", "w-a", "llama"))
+	p.rotation.next("key", []string{"node-a"}, []string{"node-a"}, nil)
+
+	p.evictKey("key")
+	if _, kept := p.rotation.groups["key"]; kept {
+		t.Error("rotation kept the groups of an evicted key")
+	}
+}
+
+func TestSelectNodeForTagLeavesFullPinnedNode(t *testing.T) {
+	p := newTestProxy(t, map[string]string{"NODE_WEIGHTS": `{"default": {"capacity": 1}}`})
+	seedWorkloads(p, "key", testWorkload("node-a", "w-a", "llama"), testWorkload("node-b", "w-b", "llama"))
+
+	r := httptest.NewRequest(http.MethodPost, "/tags/llama/v1/chat/completions", nil)
+	r.Header.Set(p.affinityHeader, "session")
+	p.state.SetAffinity(affinityKey("key", "llama", "session"), "node-a", time.Minute)
+
+	if node, err := p.selectNodeForTag(r, "key", "llama"); err != nil || node != "node-a" {
+		t.Fatalf("selectNodeForTag = %s, %v, want pinned node-a", node, err)
+	}
+
+	release := p.trackInFlight("key", "node-a")
+	defer release()
+	node, err := p.selectNodeForTag(r, "key", "llama")
+	if err != nil || node != "node-b" {
+		t.Fatalf("selectNodeForTag with node-a full = %s, %v, want node-b", node, err)
+	}
+	if pinned, _, _ := p.state.GetAffinity(affinityKey("key", "llama", "session")); pinned != "node-b" {
+		t.Errorf("session pinned to %s, want node-b", pinned)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -21,10 +22,44 @@ type Workload struct {
 }
 
 type WorkloadCache struct {
-	Workloads   []Workload
-	LastFetch   time.Time
-	LastAccess  time.Time
-	StopRefresh chan struct{}
+	Workloads    []Workload
+	LastFetch    time.Time
+	LastAccess   time.Time
+	StopRefresh  chan struct{}
+	Revalidating bool
+	Restored     bool // workloads came from a snapshot and have not been refreshed yet
+	ExpiryWarned map[string]bool
+	LastError    string
+	LastErrorAt  time.Time
+}
+
+// CachePolicy controls how long cached workloads are served. Data younger than
+// FreshTTL is served as is; for StaleWhileRevalidate after that it is served
+// while a background refresh runs; for StaleIfError after FreshTTL it is
+// served when a refresh fails.
+type CachePolicy struct {
+	FreshTTL             time.Duration
+	StaleWhileRevalidate time.Duration
+	StaleIfError         time.Duration
+}
+
+func loadCachePolicy() (CachePolicy, error) {
+	policy := CachePolicy{
+		FreshTTL:             getEnvDuration("CACHE_FRESH_TTL", 60*time.Second),
+		StaleWhileRevalidate: getEnvDuration("CACHE_STALE_WHILE_REVALIDATE", 2*time.Minute),
+		StaleIfError:         getEnvDuration("CACHE_STALE_IF_ERROR", 10*time.Minute),
+	}
+	// FreshTTL is also the refresh interval, so it must be positive
+	if policy.FreshTTL <= 0 {
+		return policy, fmt.Errorf("CACHE_FRESH_TTL must be positive, got %v", policy.FreshTTL)
+	}
+	if policy.StaleWhileRevalidate < 0 {
+		return policy, fmt.Errorf("CACHE_STALE_WHILE_REVALIDATE must not be negative, got %v", policy.StaleWhileRevalidate)
+	}
+	if policy.StaleIfError < 0 {
+		return policy, fmt.Errorf("CACHE_STALE_IF_ERROR must not be negative, got %v", policy.StaleIfError)
+	}
+	return policy, nil
 }
 
 func (p *ProxyServer) updateLastAccess(apiKey string) {
@@ -36,7 +71,44 @@ func (p *ProxyServer) updateLastAccess(apiKey string) {
 	}
 }
 
-func (p *ProxyServer) fetchWorkloads(apiKey string) ([]Workload, error) {
+// fetchWorkloads fetches workloads for an API key, sharing the result of any
+// fetch for the same key that is already in flight
+func (p *ProxyServer) fetchWorkloads(ctx context.Context, apiKey string) ([]Workload, error) {
+	span := spanFromContext(ctx)
+	span.SetAttr("c3.cache_hit", false)
+	workloads, err, shared := p.fetches.Do(apiKey, func() ([]Workload, error) {
+		p.workloadFetches.Inc()
+		return p.requestWorkloads(ctx, apiKey)
+	})
+	span.SetAttr("c3.fetch_shared", shared)
+	if shared {
+		p.workloadFetchesSaved.Inc()
+		p.logger.Debug("🤝 Shared in-flight workload fetch for API key %s...", maskKey(apiKey))
+	}
+	if err != nil {
+		p.cacheLock.Lock()
+		if cache, exists := p.workloadCache[apiKey]; exists {
+			cache.LastError = err.Error()
+			cache.LastErrorAt = time.Now()
+		}
+		p.cacheLock.Unlock()
+	}
+	return workloads, err
+}
+
+// requestWorkloads calls the Comput3 workloads API. The result may be shared
+// with other callers, so the request outlives ctx's cancellation and is
+// bounded by the API client's timeout instead.
+func (p *ProxyServer) requestWorkloads(ctx context.Context, apiKey string) (workloads []Workload, err error) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), p.apiClient.Timeout)
+	defer cancel()
+	ctx, span := p.tracer.Start(ctx, "workloads.fetch", spanKindClient)
+	defer func() {
+		span.RecordError(err)
+		span.SetAttr("c3.workloads", len(workloads))
+		span.End()
+	}()
+
 	body := map[string]bool{
 		"running": true,
 	}
@@ -45,7 +117,8 @@ func (p *ProxyServer) fetchWorkloads(apiKey string) ([]Workload, error) {
 		return nil, err
 	}
 
-	req, err := http.NewRequest(
+	req, err := http.NewRequestWithContext(
+		ctx,
 		"POST",
 		fmt.Sprintf("%s/workloads", p.apiURL),
 		bytes.NewBuffer(jsonBody),
@@ -57,20 +130,21 @@ func (p *ProxyServer) fetchWorkloads(apiKey string) ([]Workload, error) {
 	req.Header.Set("X-C3-API-KEY", apiKey)
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("accept", "application/json")
+	span.inject(req.Header)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := p.apiClient.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, newProxyError(http.StatusBadGateway, CodeWorkloadsAPIError,
+			"failed to fetch workloads from the Comput3 API").wrap(err)
 	}
 	defer resp.Body.Close()
+	span.SetAttr("http.response.status_code", resp.StatusCode)
 
 	if resp.StatusCode != http.StatusOK {
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("workloads API returned %d: %s", resp.StatusCode, string(bodyBytes))
+		return nil, &WorkloadsAPIError{StatusCode: resp.StatusCode, Body: string(bodyBytes)}
 	}
 
-	var workloads []Workload
 	if err := json.NewDecoder(resp.Body).Decode(&workloads); err != nil {
 		return nil, err
 	}
@@ -80,24 +154,31 @@ func (p *ProxyServer) fetchWorkloads(apiKey string) ([]Workload, error) {
 
 // forceRefreshWorkloads forces an immediate refresh of workloads for an API key
 // regardless of cache state
-func (p *ProxyServer) forceRefreshWorkloads(apiKey string) ([]Workload, error) {
-	p.logger.Debug("🔄 Forcing workload refresh for API key %s...", apiKey[:8])
+func (p *ProxyServer) forceRefreshWorkloads(ctx context.Context, apiKey string) ([]Workload, error) {
+	p.logger.Debug("🔄 Forcing workload refresh for API key %s...", maskKey(apiKey))
 
-	workloads, err := p.fetchWorkloads(apiKey)
+	workloads, err := p.loadWorkloads(ctx, apiKey)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch workloads: %v", err)
+		return nil, fmt.Errorf("failed to fetch workloads: %w", err)
 	}
 
-	p.updateCache(apiKey, workloads)
+	return workloads, nil
+}
 
-	// Ensure refresh cycle is running
-	p.cacheLock.RLock()
-	cache, exists := p.workloadCache[apiKey]
-	p.cacheLock.RUnlock()
+// loadWorkloads fetches workloads and, only once the Comput3 API has accepted
+// the key, tracks it and ensures its refresh cycle is running
+func (p *ProxyServer) loadWorkloads(ctx context.Context, apiKey string) ([]Workload, error) {
+	workloads, err := p.fetchWorkloads(ctx, apiKey)
+	if err != nil {
+		p.recordFetchError(apiKey, err)
+		return nil, err
+	}
 
-	if !exists || cache == nil {
-		p.startCacheRefresh(apiKey)
+	if err := p.ensureTracked(apiKey); err != nil {
+		return nil, err
 	}
+	p.updateCache(apiKey, workloads)
+	p.startCacheRefresh(apiKey)
 
 	return workloads, nil
 }
@@ -148,29 +229,32 @@ func (p *ProxyServer) updateCache(apiKey string, workloads []Workload) {
 	// Log node changes
 	for node := range newNodes {
 		if !oldNodes[node] {
-			p.logger.Info("🆕 New node added: %s for API key %s...", node, apiKey[:8])
+			p.logger.Info("🆕 New node added: %s for API key %s...", node, maskKey(apiKey))
 		}
 	}
 
 	for node := range oldNodes {
 		if !newNodes[node] {
-			p.logger.Info("🔌 Node removed: %s for API key %s...", node, apiKey[:8])
+			p.logger.Info("🔌 Node removed: %s for API key %s...", node, maskKey(apiKey))
 		}
 	}
 
 	// Log workload status changes
 	if cache.Workloads == nil {
 		if runningCount > 0 {
-			p.logger.Info("✨ Initial workloads for API key %s...: %d running", apiKey[:8], runningCount)
+			p.logger.Info("✨ Initial workloads for API key %s...: %d running", maskKey(apiKey), runningCount)
 		} else {
-			p.logger.Info("💤 No running workloads for API key %s...", apiKey[:8])
+			p.logger.Info("💤 No running workloads for API key %s...", maskKey(apiKey))
 		}
 	} else if runningCount == 0 {
-		p.logger.Info("🛑 All workloads stopped for API key %s...", apiKey[:8])
+		p.logger.Info("🛑 All workloads stopped for API key %s...", maskKey(apiKey))
 	}
 
+	p.warnExpiring(apiKey, cache, workloads)
+
 	cache.Workloads = workloads
 	cache.LastFetch = time.Now()
+	cache.Restored = false
 	p.tagMappings[apiKey] = tagMap
 }
 
@@ -182,7 +266,7 @@ func (p *ProxyServer) startCacheRefresh(apiKey string) {
 	if exists && existingCache != nil && existingCache.StopRefresh != nil {
 		existingCache.LastAccess = time.Now()
 		p.cacheLock.Unlock()
-		p.logger.Debug("💫 Cache refresh already running for API key: %s...", apiKey[:8])
+		p.logger.Debug("💫 Cache refresh already running for API key: %s...", maskKey(apiKey))
 		return
 	}
 
@@ -205,20 +289,29 @@ func (p *ProxyServer) startCacheRefresh(apiKey string) {
 	}
 	p.cacheLock.Unlock()
 
-	p.logger.Info("🔄 Starting cache refresh cycle for API key: %s...", apiKey[:8])
+	p.logger.Info("🔄 Starting cache refresh cycle for API key: %s...", maskKey(apiKey))
 
 	go func() {
-		ticker := time.NewTicker(60 * time.Second)
+		ticker := time.NewTicker(p.cachePolicy.FreshTTL)
 		defer ticker.Stop()
 
 		for {
+			// Workloads were just fetched by the caller, so wait for the next tick first
+			select {
+			case <-ticker.C:
+				p.logger.Debug("⏰ Cache refresh tick for %s...", maskKey(apiKey))
+			case <-newCache.StopRefresh:
+				p.logger.Info("🛑 Stopping cache refresh for %s...", maskKey(apiKey))
+				return
+			}
+
 			// Check for inactivity before fetching workloads
 			p.cacheLock.RLock()
 			cache, cacheExists := p.workloadCache[apiKey]
 			if !cacheExists || cache == nil {
 				// Cache was deleted while we were running
 				p.cacheLock.RUnlock()
-				p.logger.Warn("⚠️  Cache for API key %s... was deleted, stopping refresh cycle", apiKey[:8])
+				p.logger.Warn("⚠️  Cache for API key %s... was deleted, stopping refresh cycle", maskKey(apiKey))
 				return
 			}
 
@@ -244,13 +337,14 @@ func (p *ProxyServer) startCacheRefresh(apiKey string) {
 			if inactivityDuration > 180*time.Second && !hasActiveRequests {
 				// 3 minutes of inactivity with no requests - stop refreshing
 				p.logger.Info("⏳ API key %s... inactive for %v with no active requests, stopping refresh cycle",
-					apiKey[:8], inactivityDuration.Round(time.Second))
+					maskKey(apiKey), inactivityDuration.Round(time.Second))
 
 				p.cacheLock.Lock()
 				// Only delete if this is our refresh cycle (avoid race conditions)
 				if currentCache, exists := p.workloadCache[apiKey]; exists && currentCache == cache {
 					delete(p.workloadCache, apiKey)
 					delete(p.tagMappings, apiKey)
+					p.rotation.forget(apiKey)
 				}
 				p.cacheLock.Unlock()
 
@@ -258,37 +352,32 @@ func (p *ProxyServer) startCacheRefresh(apiKey string) {
 			} else if inactivityDuration > 60*time.Second && !hasActiveRequests {
 				// Log that we're still waiting but not deleting yet
 				p.logger.Debug("⏳ API key %s... inactive for %v but continuing refresh cycle",
-					apiKey[:8], inactivityDuration.Round(time.Second))
+					maskKey(apiKey), inactivityDuration.Round(time.Second))
 			}
 
 			// Even if we're going to stop refreshing soon, fetch the latest workloads
-			workloads, err := p.fetchWorkloads(apiKey)
+			workloads, err := p.fetchWorkloads(context.Background(), apiKey)
 			if err != nil {
-				p.logger.Error("Failed fetching workloads for %s: %v", apiKey[:8], err)
+				p.logger.Error("Failed fetching workloads for %s: %v", maskKey(apiKey), err)
+				if p.recordFetchError(apiKey, err) {
+					return
+				}
 			} else {
 				p.updateCache(apiKey, workloads)
 
 				// Don't stop refresh cycle when no workloads are found
 				// This fixes the premature stopping issue
 				if len(workloads) == 0 {
-					p.logger.Warn("💤 No workloads found for %s, but continuing refresh cycle", apiKey[:8])
+					p.logger.Warn("💤 No workloads found for %s, but continuing refresh cycle", maskKey(apiKey))
 				} else {
-					p.logger.Debug("✨ Refreshed %d workloads for API key %s...", len(workloads), apiKey[:8])
+					p.logger.Debug("✨ Refreshed %d workloads for API key %s...", len(workloads), maskKey(apiKey))
 				}
 			}
-
-			select {
-			case <-ticker.C:
-				p.logger.Debug("⏰ Cache refresh tick for %s...", apiKey[:8])
-			case <-newCache.StopRefresh:
-				p.logger.Info("🛑 Stopping cache refresh for %s...", apiKey[:8])
-				return
-			}
 		}
 	}()
 }
 
-func (p *ProxyServer) getWorkloads(apiKey string) ([]Workload, error) {
+func (p *ProxyServer) getWorkloads(ctx context.Context, apiKey string) ([]Workload, error) {
 	p.cacheLock.RLock()
 	cache, exists := p.workloadCache[apiKey]
 	p.cacheLock.RUnlock()
@@ -297,32 +386,18 @@ func (p *ProxyServer) getWorkloads(apiKey string) ([]Workload, error) {
 	p.updateLastAccess(apiKey)
 
 	if !exists {
-		// No cache exists, start refresh cycle and fetch workloads
-		p.startCacheRefresh(apiKey)
-
-		workloads, err := p.fetchWorkloads(apiKey)
-		if err != nil {
-			return nil, err
+		// No cache exists, seed it from the snapshot if possible, otherwise
+		// fetch workloads and start the refresh cycle
+		if workloads, restored := p.restoreSnapshot(apiKey); restored {
+			return workloads, nil
 		}
-		p.updateCache(apiKey, workloads)
-		return workloads, nil
+		return p.loadWorkloads(ctx, apiKey)
 	}
 
 	if cache.Workloads == nil || len(cache.Workloads) == 0 {
-		// Cache exists but no workloads, fetch them
-		workloads, err := p.fetchWorkloads(apiKey)
-		if err != nil {
-			return nil, err
-		}
-
-		// Always update the cache with latest workloads
-		p.updateCache(apiKey, workloads)
-
-		// Even if workloads are empty, ensure refresh cycle is running
-		// as long as the API key is active
-		p.startCacheRefresh(apiKey)
-
-		return workloads, nil
+		// Cache exists but no workloads, fetch them. Even if workloads are
+		// empty the refresh cycle keeps running as long as the key is active
+		return p.loadWorkloads(ctx, apiKey)
 	}
 
 	// Check if we have any running nodes in our cache
@@ -334,33 +409,85 @@ func (p *ProxyServer) getWorkloads(apiKey string) ([]Workload, error) {
 			break
 		}
 	}
-	staleCache := time.Since(cache.LastFetch) > 60*time.Second
+	cached := cache.Workloads
+	age := time.Since(cache.LastFetch)
+	restored := cache.Restored
 	p.cacheLock.RUnlock()
 
-	// If no running nodes or cache is stale, force a refresh
-	if !hasRunningNodes || staleCache {
-		var cacheStatus string
-		if !hasRunningNodes {
-			cacheStatus = "no running nodes"
-		} else {
-			cacheStatus = "stale data"
+	policy := p.cachePolicy
+	if restored && age <= policy.FreshTTL+policy.StaleIfError {
+		// Snapshot data is served while the first refresh runs, but no longer
+		// than any other data kept for a failing Comput3 API
+		p.revalidate(apiKey, cache)
+		return cached, nil
+	}
+
+	if hasRunningNodes {
+		if age <= policy.FreshTTL {
+			return cached, nil
+		}
+		if age <= policy.FreshTTL+policy.StaleWhileRevalidate {
+			// Serve stale data immediately and refresh in the background
+			p.revalidate(apiKey, cache)
+			return cached, nil
 		}
-		p.logger.Debug("🔄 Cache has %s - forcing refresh for API key %s...", cacheStatus, apiKey[:8])
+	}
 
-		workloads, err := p.fetchWorkloads(apiKey)
-		if err != nil {
-			// On error, return the cached workloads as fallback
-			p.logger.Warn("⚠️  Failed to refresh workloads: %v, using cached data", err)
-			p.cacheLock.RLock()
-			defer p.cacheLock.RUnlock()
-			return cache.Workloads, nil
+	// No running nodes or data too stale to serve without refreshing first
+	var cacheStatus string
+	if !hasRunningNodes {
+		cacheStatus = "no running nodes"
+	} else {
+		cacheStatus = "stale data"
+	}
+	p.logger.Debug("🔄 Cache has %s - forcing refresh for API key %s...", cacheStatus, maskKey(apiKey))
+
+	workloads, err := p.fetchWorkloads(ctx, apiKey)
+	if err != nil {
+		if p.recordFetchError(apiKey, err) {
+			return nil, err
 		}
+		if age > policy.FreshTTL+policy.StaleIfError {
+			p.logger.Warn("⚠️  Failed to refresh workloads: %v, cached data too old to use (%v)",
+				err, age.Round(time.Second))
+			return nil, err
+		}
+		// Within the stale-if-error window, return the cached workloads as fallback
+		p.logger.Warn("⚠️  Failed to refresh workloads: %v, using cached data", err)
+		return cached, nil
+	}
 
-		p.updateCache(apiKey, workloads)
-		return workloads, nil
+	p.updateCache(apiKey, workloads)
+	return workloads, nil
+}
+
+// revalidate refreshes a key's workloads in the background unless a
+// background refresh is already running for it
+func (p *ProxyServer) revalidate(apiKey string, cache *WorkloadCache) {
+	p.cacheLock.Lock()
+	if cache.Revalidating {
+		p.cacheLock.Unlock()
+		return
 	}
+	cache.Revalidating = true
+	p.cacheLock.Unlock()
 
-	p.cacheLock.RLock()
-	defer p.cacheLock.RUnlock()
-	return cache.Workloads, nil
+	p.logger.Debug("🔁 Serving stale workloads for API key %s... while revalidating", maskKey(apiKey))
+
+	go func() {
+		defer func() {
+			p.cacheLock.Lock()
+			cache.Revalidating = false
+			p.cacheLock.Unlock()
+		}()
+
+		workloads, err := p.fetchWorkloads(context.Background(), apiKey)
+		if err != nil {
+			if !p.recordFetchError(apiKey, err) {
+				p.logger.Warn("⚠️  Background refresh failed for API key %s...: %v", maskKey(apiKey), err)
+			}
+			return
+		}
+		p.updateCache(apiKey, workloads)
+	}()
 }
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoadCachePolicy(t *testing.T) {
+	for name, env := range map[string][2]string{
+		"zero fresh TTL":    {"CACHE_FRESH_TTL", "0s"},
+		"negative SWR":      {"CACHE_STALE_WHILE_REVALIDATE", "-1s"},
+		"negative if-error": {"CACHE_STALE_IF_ERROR", "-1s"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(env[0], env[1])
+			if _, err := loadCachePolicy(); err == nil {
+				t.Errorf("%s=%s was accepted", env[0], env[1])
+			}
+		})
+	}
+}
+
+// workloadsAPI serves a workload on node, or fails while down is set,
+// counting calls
+type workloadsAPI struct {
+	node  atomic.Value
+	down  atomic.Bool
+	calls atomic.Int32
+}
+
+func newWorkloadsAPI(t *testing.T) (*workloadsAPI, string) {
+	t.Helper()
+	api := &workloadsAPI{}
+	api.node.Store("node-a")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		api.calls.Add(1)
+		if api.down.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode([]Workload{testWorkload(api.node.Load().(string), "w-1", "llama")})
+	}))
+	t.Cleanup(server.Close)
+	return api, server.URL
+}
+
+// cachedAt seeds testKey's workloads as fetched age ago
+func cachedAt(p *ProxyServer, age time.Duration) {
+	seedWorkloads(p, testKey, testWorkload("node-a", "w-1", "llama"))
+	p.cacheLock.Lock()
+	p.workloadCache[testKey].LastFetch = time.Now().Add(-age)
+	p.cacheLock.Unlock()
+}
+
+func swrProxy(t *testing.T) (*ProxyServer, *workloadsAPI) {
+	t.Helper()
+	api, url := newWorkloadsAPI(t)
+	api.node.Store("node-b")
+	p := newTestProxy(t, map[string]string{
+		"API_URL":                      url,
+		"CACHE_FRESH_TTL":              "1m",
+		"CACHE_STALE_WHILE_REVALIDATE": "2m",
+		"CACHE_STALE_IF_ERROR":         "10m",
+	})
+	return p, api
+}
+
+// firstNode fetches testKey's workloads and returns the first one's node
+func firstNode(t *testing.T, p *ProxyServer) string {
+	t.Helper()
+	workloads, err := p.getWorkloads(context.Background(), testKey)
+	if err != nil || len(workloads) == 0 {
+		t.Fatalf("getWorkloads = %v, %v", workloads, err)
+	}
+	return workloads[0].Node
+}
+
+func TestFreshWorkloadsAreServedFromCache(t *testing.T) {
+	p, api := swrProxy(t)
+	cachedAt(p, 30*time.Second)
+
+	if node := firstNode(t, p); node != "node-a" || api.calls.Load() != 0 {
+		t.Errorf("served %s after %d API calls, want cached node-a and no calls", node, api.calls.Load())
+	}
+}
+
+func TestStaleWorkloadsAreServedWhileRevalidating(t *testing.T) {
+	p, api := swrProxy(t)
+	cachedAt(p, 2*time.Minute)
+
+	// The stale answer comes back at once and a single refresh runs behind it
+	if node := firstNode(t, p); node != "node-a" {
+		t.Fatalf("served %s, want stale node-a without waiting", node)
+	}
+	firstNode(t, p)
+	firstNode(t, p)
+	waitFor(t, "the background refresh", func() bool {
+		p.cacheLock.RLock()
+		defer p.cacheLock.RUnlock()
+		return p.workloadCache[testKey].Workloads[0].Node == "node-b"
+	})
+	if api.calls.Load() != 1 {
+		t.Errorf("%d API calls, want one background refresh", api.calls.Load())
+	}
+	if node := firstNode(t, p); node != "node-b" {
+		t.Errorf("served %s after the refresh, want node-b", node)
+	}
+}
+
+func TestTooStaleWorkloadsWaitForRefresh(t *testing.T) {
+	p, api := swrProxy(t)
+	cachedAt(p, 5*time.Minute)
+
+	if node := firstNode(t, p); node != "node-b" || api.calls.Load() != 1 {
+		t.Errorf("served %s after %d API calls, want a fresh node-b", node, api.calls.Load())
+	}
+}
+
+func TestStaleIfError(t *testing.T) {
+	p, api := swrProxy(t)
+	api.down.Store(true)
+
+	// Within the stale-if-error window the cached workloads cover the failure
+	cachedAt(p, 5*time.Minute)
+	if node := firstNode(t, p); node != "node-a" {
+		t.Errorf("served %s while the API was down, want cached node-a", node)
+	}
+
+	cachedAt(p, 15*time.Minute)
+	if workloads, err := p.getWorkloads(context.Background(), testKey); err == nil {
+		t.Errorf("served %d workloads past the stale-if-error window", len(workloads))
+	}
+}